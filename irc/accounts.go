@@ -0,0 +1,231 @@
+package irc
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrAccountExists      = errors.New("account already exists")
+	ErrAccountNotFound    = errors.New("account not found")
+	ErrAccountReserved    = errors.New("account name is reserved")
+	ErrBadAccountName     = errors.New("invalid account name")
+	ErrRegistrationClosed = errors.New("account registration is disabled")
+	ErrUnacceptablePasswd = errors.New("unacceptable password")
+)
+
+// CanonicalAccountName returns the case-insensitive form of an account
+// name used to store and compare accounts.
+func CanonicalAccountName(account string) string {
+	return NewName(account).ToLower().String()
+}
+
+// RegisterAccount creates a new account with the given password and
+// optional email address
+func (server *Server) RegisterAccount(account, password, email string) error {
+	if server.Config().Registration.Disabled {
+		return ErrRegistrationClosed
+	}
+
+	if !NewName(account).IsNickname() {
+		return ErrBadAccountName
+	}
+
+	// accounts of operator blocks would make whoever registers them an
+	// operator
+	if server.IsOperAccount(account) {
+		return ErrAccountReserved
+	}
+
+	if password == "" || password == "*" {
+		return ErrUnacceptablePasswd
+	}
+
+	return server.accounts.Create(account, password, email)
+}
+
+// VerifyAccount checks the password of an account, deriving SCRAM
+// credentials for accounts (e.g. from the config) that have none yet.
+func (server *Server) VerifyAccount(account, password string) error {
+	if err := server.accounts.Verify(account, password); err != nil {
+		return err
+	}
+
+	if _, ok := server.accounts.Scram(account); !ok {
+		if err := server.accounts.Set(account, password); err != nil {
+			log.Errorf("error deriving SCRAM credentials for %s: %s", account, err)
+		}
+	}
+
+	return nil
+}
+
+// ChangePassword changes the password of an existing account
+func (server *Server) ChangePassword(account, password string) error {
+	if password == "" || password == "*" {
+		return ErrUnacceptablePasswd
+	}
+
+	if _, ok := server.accounts.Get(account); !ok {
+		return ErrAccountNotFound
+	}
+
+	return server.accounts.Set(account, password)
+}
+
+// DropAccount deletes an account, the registrations of the channels it
+// founded and its vhost, and logs out any clients using it
+func (server *Server) DropAccount(account string) error {
+	if _, ok := server.accounts.Get(account); !ok {
+		return ErrAccountNotFound
+	}
+
+	if err := server.accounts.Delete(account); err != nil {
+		return err
+	}
+
+	account = CanonicalAccountName(account)
+	server.clients.Range(func(_ Name, client *Client) bool {
+		if client.sasl.Id() == account {
+			client.Logout()
+		}
+		return true
+	})
+
+	// whoever registers the name next must not inherit them
+	for _, name := range server.channelStore.Founded(account) {
+		if err := server.channelStore.Delete(name); err != nil {
+			log.Errorf("error dropping %s of %s: %s", name, account, err)
+			continue
+		}
+		if channel := server.channels.Get(name); channel != nil {
+			channel.founder = ""
+		} else {
+			server.DeleteHistory(name.ToLower().String())
+		}
+	}
+	if err := server.vhosts.Delete(account); err != nil {
+		log.Errorf("error deleting the vhost of %s: %s", account, err)
+	}
+
+	return nil
+}
+
+// Login logs the client into the account
+func (client *Client) Login(account string) {
+	account = CanonicalAccountName(account)
+	if client.nickTimer != nil {
+		client.nickTimer.Stop()
+	}
+
+	client.sasl.Login(account)
+	client.RplLoggedIn(account)
+
+	if vhost := client.server.VHost(account); vhost != "" {
+		client.SetVHost(vhost)
+	}
+	client.server.AutoOper(client)
+
+	if err := client.server.accounts.Touch(account); err != nil {
+		log.Errorf("error recording login to %s: %s", account, err)
+	}
+
+	client.SetFlag(Registered, true)
+	client.Reply(
+		RplModeChanges(
+			client, client,
+			ModeChanges{
+				&ModeChange{mode: Registered, op: Add},
+			},
+		),
+	)
+	client.server.Relay(client, NewStringReply(client, ACCOUNT, "%s", account))
+}
+
+// Logout logs the client out of its account
+func (client *Client) Logout() {
+	if vhost := client.server.VHost(client.sasl.Id()); vhost != "" && client.vhost == vhost {
+		client.SetVHost("")
+	}
+	client.sasl.Reset()
+	client.RplLoggedOut()
+
+	client.SetFlag(Registered, false)
+	client.Reply(
+		RplModeChanges(
+			client, client,
+			ModeChanges{
+				&ModeChange{mode: Registered, op: Remove},
+			},
+		),
+	)
+	client.server.Relay(client, NewStringReply(client, ACCOUNT, "*"))
+}
+
+//
+// REGISTER (draft/account-registration)
+//
+
+func (msg *RegisterCommand) HandleRegServer(server *Server) {
+	client := msg.Client()
+	if !client.authorized {
+		client.ErrPasswdMismatch()
+		client.Quit("bad password")
+		return
+	}
+
+	msg.HandleServer(server)
+}
+
+func (msg *RegisterCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	account := msg.account
+	if account == "*" {
+		if !client.HasNick() {
+			client.Reply(RplFail(client, REGISTER, "NEED_NICK", account,
+				"You must choose a nickname before registering"))
+			return
+		}
+		account = client.Nick().String()
+	}
+
+	if client.sasl.Id() != "" {
+		client.Reply(RplFail(client, REGISTER, "ALREADY_AUTHENTICATED", account,
+			"You are already logged in"))
+		return
+	}
+
+	email := msg.email
+	if email == "*" {
+		email = ""
+	}
+
+	switch err := server.RegisterAccount(account, msg.password, email); err {
+	case nil:
+		client.Reply(NewStringReply(server, REGISTER, "SUCCESS %s :%s",
+			account, "Account successfully registered"))
+		client.Login(account)
+
+	case ErrAccountExists:
+		client.Reply(RplFail(client, REGISTER, "ACCOUNT_EXISTS", account,
+			"Account already exists"))
+
+	case ErrBadAccountName:
+		client.Reply(RplFail(client, REGISTER, "BAD_ACCOUNT_NAME", account,
+			"Invalid account name"))
+
+	case ErrAccountReserved:
+		client.Reply(RplFail(client, REGISTER, "BAD_ACCOUNT_NAME", account,
+			"Account name is reserved"))
+
+	case ErrUnacceptablePasswd:
+		client.Reply(RplFail(client, REGISTER, "UNACCEPTABLE_PASSWORD", account,
+			"Unacceptable password"))
+
+	default:
+		client.Reply(RplFail(client, REGISTER, "TEMPORARILY_UNAVAILABLE", account,
+			err.Error()))
+	}
+}
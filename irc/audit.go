@@ -0,0 +1,140 @@
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AuditEvent is the kind of action recorded in the audit log
+type AuditEvent string
+
+const (
+	AuditChgHost    AuditEvent = "chghost"
+	AuditDLine      AuditEvent = "dline"
+	AuditKick       AuditEvent = "kick"
+	AuditKill       AuditEvent = "kill"
+	AuditKLine      AuditEvent = "kline"
+	AuditMode       AuditEvent = "mode"
+	AuditOper       AuditEvent = "oper"
+	AuditOperFailed AuditEvent = "oper-failed"
+	AuditOperNick   AuditEvent = "onick"
+	AuditRehash     AuditEvent = "rehash"
+	AuditUnDLine    AuditEvent = "undline"
+	AuditUnKLine    AuditEvent = "unkline"
+	AuditVHost      AuditEvent = "vhost"
+)
+
+// AuditEntry is an operator or channel operator action
+type AuditEntry struct {
+	Time    time.Time  `json:"time"`
+	Event   AuditEvent `json:"event"`
+	Actor   string     `json:"actor"`
+	Target  string     `json:"target,omitempty"`
+	Channel string     `json:"channel,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+}
+
+func NewAuditEntry(event AuditEvent, actor *Client, target, reason string) *AuditEntry {
+	return &AuditEntry{
+		Time:   time.Now().UTC(),
+		Event:  event,
+		Actor:  actor.UserHost(false).String(),
+		Target: target,
+		Reason: reason,
+	}
+}
+
+func (entry *AuditEntry) String() string {
+	str := fmt.Sprintf("%s by %s", entry.Event, entry.Actor)
+	if entry.Channel != "" {
+		str += " on " + entry.Channel
+	}
+	if entry.Target != "" {
+		str += ": " + entry.Target
+	}
+	if entry.Reason != "" {
+		str += " (" + entry.Reason + ")"
+	}
+	return str
+}
+
+// AuditLog writes audit entries as JSON lines to a file and as notices to
+// operators with the server notice mode (+s)
+type AuditLog struct {
+	sync.Mutex
+	file    *os.File
+	notices bool
+}
+
+// NewAuditLog opens the audit log appending to filename, if not empty
+func NewAuditLog(filename string, notices bool) (*AuditLog, error) {
+	audit := &AuditLog{notices: notices}
+	if filename != "" {
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		audit.file = file
+	}
+	return audit, nil
+}
+
+func (audit *AuditLog) Write(entry *AuditEntry) error {
+	if audit == nil || audit.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	audit.Lock()
+	defer audit.Unlock()
+
+	_, err = audit.file.Write(append(line, '\n'))
+	return err
+}
+
+func (audit *AuditLog) Close() error {
+	if audit == nil || audit.file == nil {
+		return nil
+	}
+
+	audit.Lock()
+	defer audit.Unlock()
+
+	return audit.file.Close()
+}
+
+// Audit records entry in the audit log
+func (server *Server) Audit(entry *AuditEntry) {
+	// hold the audit log until written so a rehash does not close it
+	// under us
+	server.auditMutex.RLock()
+	audit := server.audit
+	if audit == nil {
+		server.auditMutex.RUnlock()
+		return
+	}
+	if err := audit.Write(entry); err != nil {
+		log.Errorf("error writing audit log: %s", err)
+	}
+	server.auditMutex.RUnlock()
+
+	if !audit.notices {
+		return
+	}
+	text := NewText("*** Audit: " + entry.String())
+	server.clients.Range(func(_ Name, client *Client) bool {
+		if client.HasFlag(Operator) && client.HasFlag(ServerNotice) {
+			client.Reply(RplNotice(server, client, text))
+		}
+		return true
+	})
+}
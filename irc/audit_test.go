@@ -0,0 +1,144 @@
+package irc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eris-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audit.log")
+	audit, err := NewAuditLog(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	server := &Server{name: "test.server", clients: NewClientLookupSet(), audit: audit}
+	newClient := func(nick Name, flags ...UserMode) *Client {
+		client := &Client{
+			flags:    make(map[UserMode]bool),
+			hostname: "host.test",
+			nick:     nick,
+			replies:  make(chan string, 10),
+			server:   server,
+			username: "user",
+		}
+		for _, flag := range flags {
+			client.SetFlag(flag, true)
+		}
+		server.clients.Add(client)
+		return client
+	}
+	oper := newClient("oper", Operator, ServerNotice)
+	other := newClient("other", ServerNotice)
+	target := newClient("target")
+
+	server.Audit(NewAuditEntry(AuditKill, oper, target.UserHost(false).String(), "bye"))
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &AuditEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %s", data, err)
+	}
+	if entry.Event != AuditKill || entry.Actor != "oper!user@host.test" ||
+		entry.Target != "target!user@host.test" || entry.Reason != "bye" || entry.Time.IsZero() {
+		t.Errorf("Unexpected audit entry %+v", entry)
+	}
+
+	if len(oper.replies) != 1 {
+		t.Fatalf("Expected the operator to be sent a notice")
+	}
+	if notice := <-oper.replies; !strings.Contains(notice, "kill by oper!user@host.test: target!user@host.test (bye)") {
+		t.Errorf("Unexpected audit notice %q", notice)
+	}
+	if len(other.replies) != 0 || len(target.replies) != 0 {
+		t.Error("Expected only operators with +s to be sent notices")
+	}
+}
+
+func TestAuditRehash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eris-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "ircd.yml")
+	writeConfig := func(name, audit string) {
+		data := "network:\n  name: Test\nserver:\n  name: " + name +
+			"\n  listen: [\":6667\"]\naudit:\n  file: " + audit + "\n"
+		if err := ioutil.WriteFile(filename, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("test.server", filepath.Join(dir, "audit.log"))
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := config.NewAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		name:       "test.server",
+		clients:    NewClientLookupSet(),
+		config:     config,
+		connLimits: NewConnLimiter(&config.Connections),
+		audit:      audit,
+	}
+	server.setCapabilities()
+	server.setISupport()
+	oper := &Client{flags: make(map[UserMode]bool), nick: "oper", server: server, username: "user"}
+
+	// entries written while the audit log is swapped are not lost, and
+	// clients read the config while it is replaced
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			oper.throttle(KILL)
+			server.Audit(NewAuditEntry(AuditKill, oper, "target", ""))
+		}
+		close(done)
+	}()
+	writeConfig("test.server", filepath.Join(dir, "new.log"))
+	if err := server.Rehash(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	defer server.audit.Close()
+
+	lines := 0
+	for _, name := range []string{"audit.log", "new.log"} {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		lines += strings.Count(string(data), "\n")
+	}
+	if lines != 100 {
+		t.Errorf("Expected 100 audit entries, got %d", lines)
+	}
+	if server.Config() == config || server.connLimits.config != &server.Config().Connections {
+		t.Error("Expected rehash to replace the config")
+	}
+
+	writeConfig("other.server", filepath.Join(dir, "missing", "audit.log"))
+	if err := server.Rehash(); err == nil {
+		t.Fatal("Expected rehash to fail opening the audit log")
+	}
+	if server.name != "test.server" || server.Config().Server.Name != "test.server" {
+		t.Errorf("Expected a failed rehash to leave the server unchanged, got %s", server.name)
+	}
+}
@@ -1,6 +1,8 @@
 package irc
 
 import (
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -14,22 +16,84 @@ const (
 	CAP_NAK   CapSubCommand = "NAK"
 	CAP_CLEAR CapSubCommand = "CLEAR"
 	CAP_END   CapSubCommand = "END"
+	CAP_NEW   CapSubCommand = "NEW"
+	CAP_DEL   CapSubCommand = "DEL"
 )
 
 // Capabilities are optional features a client may request from a server.
 type Capability string
 
 const (
-	MultiPrefix Capability = "multi-prefix"
-	SASL        Capability = "sasl"
+	AccountRegistration Capability = "draft/account-registration"
+	Batch               Capability = "batch"
+	CapNotify           Capability = "cap-notify"
+	ChatHistory         Capability = "draft/chathistory"
+	ChgHost             Capability = "chghost"
+	MessageTags         Capability = "message-tags"
+	MultiPrefix         Capability = "multi-prefix"
+	SASL                Capability = "sasl"
+	ServerTime          Capability = "server-time"
 )
 
-var (
-	SupportedCapabilities = CapabilitySet{
-		MultiPrefix: true,
-		SASL:        true,
+// CAP_VERSION is the latest CAP LS version understood
+const CAP_VERSION = 302
+
+// CapValues maps the capabilities supported by the server to their
+// values (e.g. sasl=PLAIN,EXTERNAL) advertised to CAP 302 clients.
+type CapValues map[Capability]string
+
+// Tokens returns the sorted capabilities, with their values if
+// withValues is true.
+func (values CapValues) Tokens(withValues bool) []string {
+	tokens := make([]string, 0, len(values))
+	for capability, value := range values {
+		if withValues && value != "" {
+			tokens = append(tokens, capability.String()+"="+value)
+		} else {
+			tokens = append(tokens, capability.String())
+		}
 	}
-)
+	sort.Strings(tokens)
+	return tokens
+}
+
+// Diff returns the capabilities added to (or whose value changed in)
+// values compared to old, and those removed.
+func (values CapValues) Diff(old CapValues) (added CapValues, removed CapValues) {
+	added, removed = make(CapValues), make(CapValues)
+	for capability, value := range values {
+		if ovalue, ok := old[capability]; !ok || ovalue != value {
+			added[capability] = value
+		}
+	}
+	for capability, value := range old {
+		if _, ok := values[capability]; !ok {
+			removed[capability] = value
+		}
+	}
+	return
+}
+
+// setCapabilities (re)generates the capabilities supported by the
+// server from its config.
+func (server *Server) setCapabilities() {
+	capabilities := CapValues{
+		Batch:       "",
+		CapNotify:   "",
+		ChgHost:     "",
+		MessageTags: "",
+		MultiPrefix: "",
+		SASL:        strings.Join(SaslMechanisms, ","),
+		ServerTime:  "",
+	}
+	if !server.Config().Registration.Disabled {
+		capabilities[AccountRegistration] = ""
+	}
+	if server.history != nil {
+		capabilities[ChatHistory] = ""
+	}
+	server.capabilities = capabilities
+}
 
 func (capability Capability) String() string {
 	return string(capability)
@@ -79,35 +143,122 @@ func (set CapabilitySet) DisableString() string {
 	return strings.Join(parts, " ")
 }
 
+// HasCapability returns true if the client has enabled capability.
+// Capabilities are changed by the client's own CAP commands and dropped by
+// REHASH from another goroutine, so they are guarded by capsMutex.
+func (client *Client) HasCapability(capability Capability) bool {
+	client.capsMutex.RLock()
+	defer client.capsMutex.RUnlock()
+
+	return client.capabilities[capability]
+}
+
+// SetCapability enables or disables capability for the client
+func (client *Client) SetCapability(capability Capability, enabled bool) {
+	client.capsMutex.Lock()
+	defer client.capsMutex.Unlock()
+
+	if enabled {
+		client.capabilities[capability] = true
+	} else {
+		delete(client.capabilities, capability)
+	}
+}
+
+// sendCapTokens sends tokens in as many CAP replies as needed, marking
+// all but the last with "*" for CAP 302 clients.
+func (client *Client) sendCapTokens(subCommand CapSubCommand, tokens []string) {
+	baseLen := len(RplCap(client, subCommand, "")) + len("* ")
+	lines := splitTokens(tokens, baseLen, 0)
+	if len(lines) == 0 {
+		lines = append(lines, []string{})
+	}
+	for i, line := range lines {
+		arg := strings.Join(line, " ")
+		if i < len(lines)-1 && client.capVersion >= CAP_VERSION {
+			client.Reply(RplCapMore(client, subCommand, arg))
+		} else {
+			client.Reply(RplCap(client, subCommand, arg))
+		}
+	}
+}
+
+// NotifyCapabilities sends CAP NEW and CAP DEL to clients with
+// cap-notify and drops removed capabilities from every client.
+func (server *Server) NotifyCapabilities(added, removed CapValues) {
+	server.clients.Range(func(_ Name, client *Client) bool {
+		notify := client.HasCapability(CapNotify)
+		for capability := range removed {
+			client.SetCapability(capability, false)
+		}
+		if !notify {
+			return true
+		}
+		if len(added) > 0 {
+			client.sendCapTokens(CAP_NEW, added.Tokens(client.capVersion >= CAP_VERSION))
+		}
+		if len(removed) > 0 {
+			client.sendCapTokens(CAP_DEL, removed.Tokens(false))
+		}
+		return true
+	})
+}
+
 func (msg *CapCommand) HandleRegServer(server *Server) {
 	client := msg.Client()
 
 	switch msg.subCommand {
 	case CAP_LS:
-		client.capState = CapNegotiating
-		client.Reply(RplCap(client, CAP_LS, SupportedCapabilities))
+		if !client.registered {
+			client.capState = CapNegotiating
+		}
+		if version, err := strconv.Atoi(msg.arg); err == nil && version > client.capVersion {
+			client.capVersion = version
+		}
+		if client.capVersion >= CAP_VERSION {
+			// CAP 302 implies cap-notify
+			client.SetCapability(CapNotify, true)
+		}
+		client.sendCapTokens(CAP_LS, server.capabilities.Tokens(client.capVersion >= CAP_VERSION))
 
 	case CAP_LIST:
-		client.Reply(RplCap(client, CAP_LIST, client.capabilities))
+		client.capsMutex.RLock()
+		tokens := make([]string, 0, len(client.capabilities))
+		for capability := range client.capabilities {
+			tokens = append(tokens, capability.String())
+		}
+		client.capsMutex.RUnlock()
+		sort.Strings(tokens)
+		client.sendCapTokens(CAP_LIST, tokens)
 
 	case CAP_REQ:
 		for capability := range msg.capabilities {
-			if !SupportedCapabilities[capability] {
-				client.Reply(RplCap(client, CAP_NAK, msg.capabilities))
+			name := Capability(strings.TrimPrefix(capability.String(), Disable.String()))
+			if _, ok := server.capabilities[name]; !ok {
+				client.Reply(RplCap(client, CAP_NAK, msg.arg))
 				return
 			}
 		}
 		for capability := range msg.capabilities {
-			client.capabilities[capability] = true
+			if strings.HasPrefix(capability.String(), Disable.String()) {
+				client.SetCapability(Capability(capability.String()[1:]), false)
+			} else {
+				client.SetCapability(capability, true)
+			}
 		}
-		client.Reply(RplCap(client, CAP_ACK, msg.capabilities))
+		client.Reply(RplCap(client, CAP_ACK, msg.arg))
 
 	case CAP_CLEAR:
+		client.capsMutex.Lock()
 		reply := RplCap(client, CAP_ACK, client.capabilities.DisableString())
 		client.capabilities = make(CapabilitySet)
+		client.capsMutex.Unlock()
 		client.Reply(reply)
 
 	case CAP_END:
+		if client.registered {
+			return
+		}
 		client.capState = CapNegotiated
 		server.tryRegister(client)
 
@@ -115,3 +266,7 @@ func (msg *CapCommand) HandleRegServer(server *Server) {
 		client.ErrInvalidCapCmd(msg.subCommand)
 	}
 }
+
+func (msg *CapCommand) HandleServer(server *Server) {
+	msg.HandleRegServer(server)
+}
@@ -0,0 +1,114 @@
+package irc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCapValuesTokens(t *testing.T) {
+	values := CapValues{
+		ServerTime: "",
+		SASL:       "PLAIN,EXTERNAL",
+	}
+
+	expected := []string{"sasl=PLAIN,EXTERNAL", "server-time"}
+	if tokens := values.Tokens(true); !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected %v, got %v", expected, tokens)
+	}
+
+	expected = []string{"sasl", "server-time"}
+	if tokens := values.Tokens(false); !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected %v, got %v", expected, tokens)
+	}
+}
+
+func TestCapValuesDiff(t *testing.T) {
+	old := CapValues{
+		AccountRegistration: "",
+		SASL:                "PLAIN",
+		ServerTime:          "",
+	}
+	values := CapValues{
+		MessageTags: "",
+		SASL:        "PLAIN,EXTERNAL",
+		ServerTime:  "",
+	}
+
+	added, removed := values.Diff(old)
+	expected := CapValues{MessageTags: "", SASL: "PLAIN,EXTERNAL"}
+	if !reflect.DeepEqual(added, expected) {
+		t.Errorf("Expected added %v, got %v", expected, added)
+	}
+	expected = CapValues{AccountRegistration: ""}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected removed %v, got %v", expected, removed)
+	}
+}
+
+func TestSendCapTokensMultiline(t *testing.T) {
+	client := &Client{
+		capVersion: CAP_VERSION,
+		server:     &Server{name: "test.server"},
+		replies:    make(chan string, 10),
+	}
+
+	tokens := make([]string, 100)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("vendor/capability-%02d", i)
+	}
+	client.sendCapTokens(CAP_LS, tokens)
+	close(client.replies)
+
+	var lines []string
+	for reply := range client.replies {
+		lines = append(lines, reply)
+	}
+	if len(lines) < 2 {
+		t.Fatalf("Expected multiple lines, got %d", len(lines))
+	}
+
+	count := 0
+	for i, line := range lines {
+		if len(line) > MAX_REPLY_LEN {
+			t.Errorf("Line %d is too long (%d)", i, len(line))
+		}
+		more := strings.Contains(line, " LS * :")
+		if more != (i < len(lines)-1) {
+			t.Errorf("Unexpected continuation marker on line %d: %q", i, line)
+		}
+		count += len(strings.Fields(line[strings.Index(line, " :")+2:]))
+	}
+	if count != len(tokens) {
+		t.Errorf("Expected %d tokens, got %d", len(tokens), count)
+	}
+}
+
+func TestNotifyCapabilitiesConcurrent(t *testing.T) {
+	server := &Server{name: "test.server", clients: NewClientLookupSet()}
+	client := &Client{
+		capabilities: CapabilitySet{CapNotify: true, ChatHistory: true},
+		nick:         "foo",
+		replies:      make(chan string, 10),
+		server:       server,
+	}
+	server.clients.Add(client)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			client.HasCapability(ChatHistory)
+		}
+		done <- true
+	}()
+	server.NotifyCapabilities(nil, CapValues{ChatHistory: ""})
+	<-done
+
+	if client.HasCapability(ChatHistory) {
+		t.Error("Expected the removed capability to be dropped")
+	}
+	if reply := <-client.replies; !strings.Contains(reply, " CAP foo DEL :draft/chathistory") {
+		t.Errorf("Expected CAP DEL, got %q", reply)
+	}
+}
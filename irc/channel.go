@@ -2,17 +2,23 @@ package irc
 
 import (
 	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Channel struct {
-	flags     *ChannelModeSet
-	lists     map[ChannelMode]*UserMaskSet
-	key       Text
-	members   *MemberSet
-	name      Name
-	server    *Server
-	topic     Text
-	userLimit uint64
+	flags      *ChannelModeSet
+	founder    string // account of the founder (registered channels)
+	lists      map[ChannelMode]*UserMaskSet
+	key        Text
+	members    *MemberSet
+	name       Name
+	playback   int // number of messages replayed to joining clients
+	registered time.Time
+	server     *Server
+	topic      Text
+	userLimit  uint64
 }
 
 // NewChannel creates a new channel from a `Server` and a `name`
@@ -30,7 +36,9 @@ func NewChannel(s *Server, name Name, addDefaultModes bool) *Channel {
 		server:  s,
 	}
 
-	if addDefaultModes {
+	if registration, ok := s.channelStore.Get(name); ok {
+		channel.restore(registration)
+	} else if addDefaultModes {
 		for _, mode := range DefaultChannelModes {
 			channel.flags.Set(mode)
 		}
@@ -41,6 +49,75 @@ func NewChannel(s *Server, name Name, addDefaultModes bool) *Channel {
 	return channel
 }
 
+// IsRegistered returns true if the channel is registered to a founder
+func (channel *Channel) IsRegistered() bool {
+	return channel.founder != ""
+}
+
+// IsFounder returns true if the client is logged into the account
+// the channel is registered to.
+func (channel *Channel) IsFounder(client *Client) bool {
+	return channel.IsRegistered() && client.sasl.Id() == channel.founder
+}
+
+// Registration returns the persistent state of the channel
+func (channel *Channel) Registration() *ChannelRegistration {
+	registration := &ChannelRegistration{
+		Name:       channel.name.String(),
+		Founder:    channel.founder,
+		Registered: channel.registered,
+		Topic:      channel.topic.String(),
+		Key:        channel.key.String(),
+		UserLimit:  channel.userLimit,
+		Flags:      channel.flags.String(),
+		Lists:      make(map[string][]string),
+		Playback:   channel.playback,
+	}
+	for mode, list := range channel.lists {
+		masks := make([]string, 0, len(list.masks))
+		for mask := range list.masks {
+			masks = append(masks, mask.String())
+		}
+		registration.Lists[mode.String()] = masks
+	}
+	return registration
+}
+
+func (channel *Channel) restore(registration *ChannelRegistration) {
+	channel.founder = registration.Founder
+	channel.registered = registration.Registered
+	channel.topic = NewText(registration.Topic)
+	channel.key = NewText(registration.Key)
+	channel.userLimit = registration.UserLimit
+	channel.playback = registration.Playback
+	for _, mode := range registration.Flags {
+		channel.flags.Set(ChannelMode(mode))
+	}
+	for _, mode := range []ChannelMode{BanMask, ExceptMask, InviteMask} {
+		if masks := registration.Lists[mode.String()]; len(masks) > 0 {
+			channel.lists[mode].AddAll(NewNames(masks))
+		}
+	}
+}
+
+// Register registers the channel to the founder's account
+func (channel *Channel) Register(founder string) error {
+	channel.founder = founder
+	channel.registered = time.Now()
+	return channel.server.channelStore.Set(channel.Registration())
+}
+
+// Save persists the state of the channel if it is registered
+func (channel *Channel) Save() {
+	if !channel.IsRegistered() {
+		return
+	}
+	err := channel.server.channelStore.Set(channel.Registration())
+	if err != nil {
+		log.Errorf("%s: error saving channel registration: %s", channel, err)
+	}
+}
+
 func (channel *Channel) IsEmpty() bool {
 	return channel.members.Count() == 0
 }
@@ -50,12 +127,16 @@ func (channel *Channel) Names(client *Client) {
 	client.RplEndOfNames(channel)
 }
 
+// ClientIsOperator returns true if the client may override the channel's
+// restrictions. Changes by remote clients were already checked by the
+// server they are connected to.
 func (channel *Channel) ClientIsOperator(client *Client) bool {
-	return client.flags[Operator] || channel.members.HasMode(client, ChannelOperator)
+	return client.HasPrivilege(PrivChanOverride) || client.link != nil ||
+		channel.members.HasMode(client, ChannelOperator)
 }
 
 func (channel *Channel) Nicks(target *Client) []string {
-	isMultiPrefix := (target != nil) && target.capabilities[MultiPrefix]
+	isMultiPrefix := (target != nil) && target.HasCapability(MultiPrefix)
 	channel.members.RLock()
 	defer channel.members.RUnlock()
 	nicks := make([]string, channel.members.Count())
@@ -96,7 +177,7 @@ func (channel *Channel) String() string {
 
 // <mode> <mode params>
 func (channel *Channel) ModeString(client *Client) (str string) {
-	isMember := client.flags[Operator] || channel.members.Has(client)
+	isMember := client.HasPrivilege(PrivSeeSecret) || channel.members.Has(client)
 	showKey := isMember && (channel.key != "")
 	showUserLimit := channel.userLimit > 0
 
@@ -133,6 +214,17 @@ func (channel *Channel) IsFull() bool {
 		(uint64(channel.members.Count()) >= channel.userLimit)
 }
 
+// Matches returns true if a mask of the list matches the real host, the
+// cloak or the vhost of the client
+func (channel *Channel) Matches(mode ChannelMode, client *Client) bool {
+	for _, mask := range client.BanMasks() {
+		if channel.lists[mode].Match(mask) {
+			return true
+		}
+	}
+	return false
+}
+
 func (channel *Channel) CheckKey(key Text) bool {
 	return (channel.key == "") || (channel.key == key)
 }
@@ -155,23 +247,25 @@ func (channel *Channel) Join(client *Client, key Text) {
 		return
 	}
 
-	isInvited := channel.lists[InviteMask].Match(client.UserHost(false))
+	isInvited := channel.Matches(InviteMask, client)
 	if !isOperator && channel.flags.Has(InviteOnly) && !isInvited {
 		client.ErrInviteOnlyChan(channel)
 		return
 	}
 
-	if channel.lists[BanMask].Match(client.UserHost(false)) &&
+	if channel.Matches(BanMask, client) &&
 		!isInvited &&
 		!isOperator &&
-		!channel.lists[ExceptMask].Match(client.UserHost(false)) {
+		!channel.Matches(ExceptMask, client) {
 		client.ErrBannedFromChan(channel)
 		return
 	}
 
 	client.channels.Add(channel)
 	channel.members.Add(client)
-	if channel.members.Count() == 1 {
+	if channel.IsFounder(client) {
+		channel.members.Get(client).Set(ChannelOperator)
+	} else if channel.members.Count() == 1 && !channel.IsRegistered() {
 		channel.members.Get(client).Set(ChannelCreator)
 		channel.members.Get(client).Set(ChannelOperator)
 	}
@@ -181,8 +275,33 @@ func (channel *Channel) Join(client *Client, key Text) {
 		member.Reply(reply)
 		return true
 	})
+	channel.server.Relay(client, reply)
 	channel.GetTopic(client)
 	channel.Names(client)
+	channel.Playback(client)
+}
+
+// Playback replays the most recent messages of the channel to a client
+// that joined it.
+func (channel *Channel) Playback(client *Client) {
+	if channel.playback == 0 || channel.server.history == nil {
+		return
+	}
+
+	items, err := channel.server.history.Get(channel.name.ToLower().String())
+	if err != nil {
+		log.Errorf("%s: error loading history: %s", channel, err)
+		return
+	}
+
+	items = SelectHistory(
+		items, "LATEST", HistorySelector{}, HistorySelector{}, channel.playback,
+	)
+	if len(items) == 0 {
+		return
+	}
+
+	client.ReplyBatch("chathistory "+channel.name.String(), items)
 }
 
 func (channel *Channel) Part(client *Client, message Text) {
@@ -196,6 +315,7 @@ func (channel *Channel) Part(client *Client, message Text) {
 		member.Reply(reply)
 		return true
 	})
+	channel.server.Relay(client, reply)
 	channel.Quit(client)
 }
 
@@ -225,12 +345,14 @@ func (channel *Channel) SetTopic(client *Client, topic Text) {
 	}
 
 	channel.topic = topic
+	channel.Save()
 
 	reply := RplTopicMsg(client, channel)
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
 		member.Reply(reply)
 		return true
 	})
+	channel.server.Relay(client, reply)
 }
 
 func (channel *Channel) CanSpeak(client *Client) bool {
@@ -244,18 +366,19 @@ func (channel *Channel) CanSpeak(client *Client) bool {
 		channel.members.HasMode(client, ChannelOperator)) {
 		return false
 	}
-	if channel.flags.Has(SecureChan) && !client.flags[SecureConn] {
+	if channel.flags.Has(SecureChan) && !client.HasFlag(SecureConn) {
 		return false
 	}
 	return true
 }
 
-func (channel *Channel) PrivMsg(client *Client, message Text) {
+func (channel *Channel) PrivMsg(client *Client, message Text, tags Tags) {
 	if !channel.CanSpeak(client) {
 		client.ErrCannotSendToChan(channel)
 		return
 	}
-	reply := RplPrivMsg(client, channel, message)
+	item := NewHistoryItem(client, PRIVMSG, channel.name, message, tags)
+	reply := WithTags(RplPrivMsg(client, channel, message), item.MessageTags())
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
 		if member == client {
 			return true
@@ -264,6 +387,8 @@ func (channel *Channel) PrivMsg(client *Client, message Text) {
 		member.Reply(reply)
 		return true
 	})
+	channel.server.Relay(client, reply)
+	channel.server.AddHistory(channel.name.ToLower().String(), item)
 }
 
 func (channel *Channel) applyModeFlag(client *Client, mode ChannelMode,
@@ -439,20 +564,28 @@ func (channel *Channel) Mode(client *Client, changes ChannelModeChanges) {
 	}
 
 	if len(applied) > 0 {
+		channel.Save()
+
+		entry := NewAuditEntry(AuditMode, client, "", applied.String())
+		entry.Channel = channel.name.String()
+		channel.server.Audit(entry)
+
 		reply := RplChannelMode(client, channel, applied)
 		channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
 			member.Reply(reply)
 			return true
 		})
+		channel.server.Relay(client, reply)
 	}
 }
 
-func (channel *Channel) Notice(client *Client, message Text) {
+func (channel *Channel) Notice(client *Client, message Text, tags Tags) {
 	if !channel.CanSpeak(client) {
 		client.ErrCannotSendToChan(channel)
 		return
 	}
-	reply := RplNotice(client, channel, message)
+	item := NewHistoryItem(client, NOTICE, channel.name, message, tags)
+	reply := WithTags(RplNotice(client, channel, message), item.MessageTags())
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
 		if member == client {
 			return true
@@ -461,6 +594,28 @@ func (channel *Channel) Notice(client *Client, message Text) {
 		member.Reply(reply)
 		return true
 	})
+	channel.server.Relay(client, reply)
+	channel.server.AddHistory(channel.name.ToLower().String(), item)
+}
+
+// TagMsg relays client-only tags to members that support message tags
+func (channel *Channel) TagMsg(client *Client, tags Tags) {
+	if !channel.CanSpeak(client) {
+		client.ErrCannotSendToChan(channel)
+		return
+	}
+	if len(tags) == 0 {
+		return
+	}
+	reply := RplTagMsg(client, channel, tags)
+	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
+		if member == client || !member.HasCapability(MessageTags) {
+			return true
+		}
+		member.Reply(reply)
+		return true
+	})
+	channel.server.Relay(client, reply)
 }
 
 func (channel *Channel) Quit(client *Client) {
@@ -471,6 +626,11 @@ func (channel *Channel) Quit(client *Client) {
 
 	if channel.IsEmpty() {
 		channel.server.channels.Remove(channel)
+		// whoever creates the channel next must not see what was said
+		// in it, only registered channels keep their history
+		if !channel.IsRegistered() {
+			channel.server.DeleteHistory(channel.name.ToLower().String())
+		}
 	}
 }
 
@@ -488,11 +648,16 @@ func (channel *Channel) Kick(client *Client, target *Client, comment Text) {
 		return
 	}
 
+	entry := NewAuditEntry(AuditKick, client, target.UserHost(false).String(), comment.String())
+	entry.Channel = channel.name.String()
+	channel.server.Audit(entry)
+
 	reply := RplKick(channel, client, target, comment)
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
 		member.Reply(reply)
 		return true
 	})
+	channel.server.Relay(client, reply)
 	channel.Quit(target)
 }
 
@@ -513,7 +678,7 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client) {
 
 	inviter.RplInviting(invitee, channel.name)
 	invitee.Reply(RplInviteMsg(inviter, invitee, channel.name))
-	if invitee.flags[Away] {
+	if invitee.HasFlag(Away) {
 		inviter.RplAway(invitee)
 	}
 }
@@ -0,0 +1,150 @@
+package irc
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ChannelRegistration is the persisted state of a registered channel
+type ChannelRegistration struct {
+	Name       string              `json:"name"`
+	Founder    string              `json:"founder"`
+	Registered time.Time           `json:"registered"`
+	Topic      string              `json:"topic"`
+	Key        string              `json:"key"`
+	UserLimit  uint64              `json:"userlimit"`
+	Flags      string              `json:"flags"`
+	Lists      map[string][]string `json:"lists"`
+	Playback   int                 `json:"playback,omitempty"`
+}
+
+type ChannelStore interface {
+	Get(name Name) (*ChannelRegistration, bool)
+	Set(registration *ChannelRegistration) error
+	Delete(name Name) error
+	Founded(account string) []Name
+}
+
+// MemoryChannelStore keeps channel registrations for the lifetime
+// of the server only.
+type MemoryChannelStore struct {
+	sync.RWMutex
+	channels map[Name]*ChannelRegistration
+}
+
+func NewMemoryChannelStore() *MemoryChannelStore {
+	return &MemoryChannelStore{
+		channels: make(map[Name]*ChannelRegistration),
+	}
+}
+
+func (store *MemoryChannelStore) Get(name Name) (*ChannelRegistration, bool) {
+	store.RLock()
+	defer store.RUnlock()
+
+	registration, ok := store.channels[name.ToLower()]
+	return registration, ok
+}
+
+func (store *MemoryChannelStore) Set(registration *ChannelRegistration) error {
+	store.Lock()
+	defer store.Unlock()
+
+	store.channels[NewName(registration.Name).ToLower()] = registration
+	return nil
+}
+
+func (store *MemoryChannelStore) Delete(name Name) error {
+	store.Lock()
+	defer store.Unlock()
+
+	delete(store.channels, name.ToLower())
+	return nil
+}
+
+// Founded returns the names of the channels registered to account
+func (store *MemoryChannelStore) Founded(account string) []Name {
+	store.RLock()
+	defer store.RUnlock()
+
+	names := make([]Name, 0)
+	for _, registration := range store.channels {
+		if registration.Founder == account {
+			names = append(names, NewName(registration.Name))
+		}
+	}
+	return names
+}
+
+// BoltChannelStore keeps channel registrations in the "channels"
+// bucket of a BoltDB database.
+type BoltChannelStore struct {
+	db *bolt.DB
+}
+
+func NewBoltChannelStore(db *bolt.DB) *BoltChannelStore {
+	return &BoltChannelStore{db: db}
+}
+
+func (store *BoltChannelStore) Get(name Name) (*ChannelRegistration, bool) {
+	var data []byte
+	store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte("channels")).Get([]byte(name.ToLower()))
+		if value != nil {
+			data = make([]byte, len(value))
+			copy(data, value)
+		}
+		return nil
+	})
+	if data == nil {
+		return nil, false
+	}
+
+	registration := &ChannelRegistration{}
+	if err := json.Unmarshal(data, registration); err != nil {
+		log.Errorf("error loading channel registration for %s: %s", name, err)
+		return nil, false
+	}
+	return registration, true
+}
+
+func (store *BoltChannelStore) Set(registration *ChannelRegistration) error {
+	data, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+
+	key := NewName(registration.Name).ToLower()
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("channels")).Put([]byte(key), data)
+	})
+}
+
+func (store *BoltChannelStore) Delete(name Name) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("channels")).Delete([]byte(name.ToLower()))
+	})
+}
+
+// Founded returns the names of the channels registered to account
+func (store *BoltChannelStore) Founded(account string) []Name {
+	names := make([]Name, 0)
+	store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("channels")).ForEach(func(key, value []byte) error {
+			registration := &ChannelRegistration{}
+			if err := json.Unmarshal(value, registration); err != nil {
+				log.Errorf("error loading channel registration for %s: %s", key, err)
+				return nil
+			}
+			if registration.Founder == account {
+				names = append(names, NewName(registration.Name))
+			}
+			return nil
+		})
+	})
+	return names
+}
@@ -0,0 +1,59 @@
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltChannelStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(filepath.Join(dir, "eris.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewBoltChannelStore(db)
+
+	if _, ok := store.Get("#test"); ok {
+		t.Error("Expected #test not to be registered")
+	}
+
+	err = store.Set(&ChannelRegistration{
+		Name:     "#Test",
+		Founder:  "admin",
+		Topic:    "Hello World!",
+		Flags:    "nt",
+		Lists:    map[string][]string{"b": {"*!*@example.com"}},
+		Playback: 25,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registration, ok := store.Get("#test")
+	if !ok {
+		t.Fatal("Expected #test to be registered")
+	}
+	if registration.Founder != "admin" || registration.Topic != "Hello World!" ||
+		registration.Playback != 25 {
+		t.Errorf("Unexpected registration: %+v", registration)
+	}
+	if bans := registration.Lists["b"]; len(bans) != 1 || bans[0] != "*!*@example.com" {
+		t.Errorf("Unexpected ban list: %v", bans)
+	}
+
+	if err := store.Delete("#TEST"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get("#test"); ok {
+		t.Error("Expected #test to be dropped")
+	}
+}
@@ -0,0 +1,158 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+func NewChanServ(server *Server) *Service {
+	service := NewService(server, "ChanServ")
+
+	service.AddCommand(
+		"REGISTER", "<#channel>",
+		"Registers a channel you are an operator of to your account",
+		chanservRegister,
+	)
+	service.AddCommand(
+		"DROP", "<#channel>",
+		"Drops the registration of a channel",
+		chanservDrop,
+	)
+	service.AddCommand(
+		"INFO", "<#channel>",
+		"Shows information about a registered channel",
+		chanservInfo,
+	)
+	service.AddCommand(
+		"SET", "<#channel> PLAYBACK <count>",
+		"Sets the number of recent messages replayed to clients joining a registered channel",
+		chanservSet,
+	)
+
+	return service
+}
+
+func chanservRegister(service *Service, client *Client, args []string) {
+	if len(args) < 1 {
+		service.Usage(client, "REGISTER")
+		return
+	}
+
+	account := client.sasl.Id()
+	if account == "" {
+		service.Notice(client, "You must be logged in to register a channel")
+		return
+	}
+
+	name := NewName(args[0])
+	channel := service.server.channels.Get(name)
+	if channel == nil {
+		service.Notice(client, "No such channel %s", name)
+		return
+	}
+
+	if !channel.members.HasMode(client, ChannelOperator) {
+		service.Notice(client, "You must be a channel operator of %s", channel)
+		return
+	}
+
+	if channel.IsRegistered() {
+		service.Notice(client, "%s is already registered", channel)
+		return
+	}
+
+	if err := channel.Register(account); err != nil {
+		service.Notice(client, "Error registering %s: %s", channel, err)
+		return
+	}
+
+	service.Notice(client, "%s is now registered to %s", channel, account)
+}
+
+func chanservDrop(service *Service, client *Client, args []string) {
+	if len(args) < 1 {
+		service.Usage(client, "DROP")
+		return
+	}
+
+	name := NewName(args[0])
+	registration, ok := service.server.channelStore.Get(name)
+	if !ok {
+		service.Notice(client, "%s is not registered", name)
+		return
+	}
+
+	if !client.HasPrivilege(PrivChanOverride) && client.sasl.Id() != registration.Founder {
+		service.Notice(client, "Only the founder of %s may drop it", name)
+		return
+	}
+
+	if err := service.server.channelStore.Delete(name); err != nil {
+		service.Notice(client, "Error dropping %s: %s", name, err)
+		return
+	}
+
+	if channel := service.server.channels.Get(name); channel != nil {
+		channel.founder = ""
+	} else {
+		service.server.DeleteHistory(name.ToLower().String())
+	}
+
+	service.Notice(client, "%s has been dropped", name)
+}
+
+func chanservInfo(service *Service, client *Client, args []string) {
+	if len(args) < 1 {
+		service.Usage(client, "INFO")
+		return
+	}
+
+	name := NewName(args[0])
+	registration, ok := service.server.channelStore.Get(name)
+	if !ok {
+		service.Notice(client, "%s is not registered", name)
+		return
+	}
+
+	service.Notice(client, "Channel: %s", registration.Name)
+	service.Notice(client, "Founder: %s", registration.Founder)
+	service.Notice(client, "Registered: %s", registration.Registered.Format(time.RFC1123))
+	if registration.Playback > 0 {
+		service.Notice(client, "Playback: %d messages", registration.Playback)
+	}
+}
+
+func chanservSet(service *Service, client *Client, args []string) {
+	if len(args) < 3 || strings.ToUpper(args[1]) != "PLAYBACK" {
+		service.Usage(client, "SET")
+		return
+	}
+
+	name := NewName(args[0])
+	channel := service.server.channels.Get(name)
+	if channel == nil || !channel.IsRegistered() {
+		service.Notice(client, "%s is not registered", name)
+		return
+	}
+
+	if !client.HasPrivilege(PrivChanOverride) && !channel.IsFounder(client) {
+		service.Notice(client, "Only the founder of %s may change its settings", channel)
+		return
+	}
+
+	count, err := strconv.Atoi(args[2])
+	if err != nil || count < 0 || count > CHATHISTORY_MAX {
+		service.Notice(client, "Playback must be between 0 and %d messages", CHATHISTORY_MAX)
+		return
+	}
+
+	channel.playback = count
+	channel.Save()
+
+	if count == 0 {
+		service.Notice(client, "Playback disabled for %s", channel)
+		return
+	}
+	service.Notice(client, "%s will replay the last %d messages to joining clients", channel, count)
+}
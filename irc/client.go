@@ -1,9 +1,10 @@
 package irc
 
 import (
-	"crypto/tls"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,17 +20,31 @@ type Client struct {
 	authorized   bool
 	awayMessage  Text
 	capabilities CapabilitySet
+	capsMutex    sync.RWMutex
 	capState     CapState
+	capVersion   int
+	certfp       string
 	channels     *ChannelSet
+	dnsbl        *DNSBLListing // blocklist the client is listed in
 	ctime        time.Time
 	flags        map[UserMode]bool
+	flagsMutex   sync.RWMutex // guards flags, oper and operClass
+	flood        *FloodLimiter
 	hasQuit      bool
 	hops         uint
 	hostname     Name
-	hostmask     Name // Cloacked hostname (SHA256)
+	hostmask     Name // Visible hostname (vhost or cloak)
+	cloak        Name // Cloak of the hostname
+	vhost        Name // Visible hostname set by an operator or account
+	ip           net.IP
 	pingTime     time.Time
 	idleTimer    *time.Timer
+	link         *Link         // link the client is reached through if remote
+	remote       *RemoteServer // server the client is connected to if remote
 	nick         Name
+	nickTimer    *time.Timer
+	oper         Name // operator block the client opered as
+	operClass    *OperClass
 	quitTimer    *time.Timer
 	realname     Text
 	registered   bool
@@ -56,8 +71,8 @@ func NewClient(server *Server, conn net.Conn) *Client {
 		replies:      make(chan string),
 	}
 
-	if _, ok := conn.(*tls.Conn); ok {
-		client.flags[SecureConn] = true
+	if IsSecure(conn) {
+		client.SetFlag(SecureConn, true)
 	}
 
 	client.Touch()
@@ -91,15 +106,34 @@ func (client *Client) readloop() {
 	var err error
 	var line string
 
-	// Set the hostname for this client.
-	client.hostname = AddrLookupHostname(client.socket.conn.RemoteAddr())
-	client.hostmask = NewName(SHA256(client.hostname.String()))
+	// Set the hostname for this client, looking its address up in the
+	// DNS blocklists at the same time.
+	client.ip = net.ParseIP(IPString(client.socket.conn.RemoteAddr()).String())
+	listing := make(chan *DNSBLListing, 1)
+	go func() {
+		listing <- client.server.dnsbl.Lookup(client.ip)
+	}()
+	client.SetHostname(AddrLookupHostname(client.socket.conn.RemoteAddr()))
+	client.certfp = CertFP(client.socket.conn)
+
+	if !client.applyDNSBL(<-listing) {
+		return
+	}
 
-	for err == nil {
+	for {
 		if line, err = client.socket.Read(); err != nil {
-			command = NewQuitCommand("connection closed")
+			client.processCommand(NewQuitCommand("connection closed"))
+			return
+		}
+
+		// Every line counts against the flood limit, including those that
+		// fail to parse, before any expensive password check.
+		command, err = ParseCommand(line)
+		if !client.throttle(lineCode(command, line)) {
+			continue
+		}
 
-		} else if command, err = ParseCommand(line); err != nil {
+		if err != nil {
 			switch err {
 			case ErrParseCommand:
 				//TODO(dan): use the real failed numeric for this (400)
@@ -108,9 +142,10 @@ func (client *Client) readloop() {
 
 			case NotEnoughArgsError:
 				// TODO
+
+			case ErrInputTooLong:
+				client.ErrInputTooLong()
 			}
-			// so the read loop will continue
-			err = nil
 			continue
 
 		} else if checkPass, ok := command.(checkPasswordCommand); ok {
@@ -231,20 +266,29 @@ func (client *Client) destroy() {
 
 	// clean up server
 
-	if _, ok := client.socket.conn.(*tls.Conn); ok {
+	client.server.clients.Remove(client)
+	if client.link != nil {
+		log.Debugf("%s: destroyed", client)
+		return
+	}
+
+	if IsSecure(client.socket.conn) {
 		client.server.metrics.GaugeVec("server", "clients").WithLabelValues("secure").Dec()
 	} else {
 		client.server.metrics.GaugeVec("server", "clients").WithLabelValues("insecure").Dec()
 	}
 
 	client.server.connections.Dec()
-	client.server.clients.Remove(client)
+	client.server.connLimits.Remove(net.ParseIP(IPString(client.socket.conn.RemoteAddr()).String()))
 
 	// clean up self
 
 	if client.idleTimer != nil {
 		client.idleTimer.Stop()
 	}
+	if client.nickTimer != nil {
+		client.nickTimer.Stop()
+	}
 	if client.quitTimer != nil {
 		client.quitTimer.Stop()
 	}
@@ -278,18 +322,20 @@ func (client *Client) HasUsername() bool {
 }
 
 func (client *Client) CanSpeak(target *Client) bool {
-	requiresSecure := client.flags[SecureOnly] || target.flags[SecureOnly]
-	isSecure := client.flags[SecureConn] && target.flags[SecureConn]
-	isOperator := client.flags[Operator]
+	requiresSecure := client.HasFlag(SecureOnly) || target.HasFlag(SecureOnly)
+	isSecure := client.HasFlag(SecureConn) && target.HasFlag(SecureConn)
+	isOperator := client.HasFlag(Operator)
 
 	return !requiresSecure || (requiresSecure && (isOperator || isSecure))
 }
 
 // <mode>
 func (c *Client) ModeString() (str string) {
+	c.flagsMutex.RLock()
 	for flag := range c.flags {
 		str += flag.String()
 	}
+	c.flagsMutex.RUnlock()
 
 	if len(str) > 0 {
 		str = "+" + str
@@ -297,6 +343,33 @@ func (c *Client) ModeString() (str string) {
 	return
 }
 
+// HasFlag returns true if the client has the user mode. Modes are
+// changed by REHASH and by operators from other goroutines, so they are
+// guarded by flagsMutex.
+func (client *Client) HasFlag(mode UserMode) bool {
+	client.flagsMutex.RLock()
+	defer client.flagsMutex.RUnlock()
+
+	return client.flags[mode]
+}
+
+// SetFlag sets or unsets the user mode of the client, returning true if
+// it changed
+func (client *Client) SetFlag(mode UserMode, enabled bool) bool {
+	client.flagsMutex.Lock()
+	defer client.flagsMutex.Unlock()
+
+	if client.flags[mode] == enabled {
+		return false
+	}
+	if enabled {
+		client.flags[mode] = true
+	} else {
+		delete(client.flags, mode)
+	}
+	return true
+}
+
 func (c *Client) UserHost(cloacked bool) Name {
 	username := "*"
 	if c.HasUsername() {
@@ -308,11 +381,31 @@ func (c *Client) UserHost(cloacked bool) Name {
 	return Name(fmt.Sprintf("%s!%s@%s", c.Nick(), username, c.hostname))
 }
 
+// BanMasks returns the masks bans are matched against: those of the real
+// host, the cloak and the visible host (a vhost) of the client
+func (c *Client) BanMasks() []Name {
+	masks := []Name{c.UserHost(false), c.UserHost(true)}
+	if c.cloak != "" && c.cloak != c.hostmask {
+		username := "*"
+		if c.HasUsername() {
+			username = c.username.String()
+		}
+		masks = append(masks, Name(fmt.Sprintf("%s!%s@%s", c.Nick(), username, c.cloak)))
+	}
+	return masks
+}
+
 func (c *Client) Server() Name {
+	if c.remote != nil {
+		return c.remote.name
+	}
 	return c.server.name
 }
 
 func (c *Client) ServerInfo() string {
+	if c.remote != nil {
+		return c.remote.description
+	}
 	return c.server.description
 }
 
@@ -344,6 +437,13 @@ func (client *Client) Friends() *ClientSet {
 	return friends
 }
 
+// SetHostname sets the hostname of the client and its cloak
+func (client *Client) SetHostname(hostname Name) {
+	client.hostname = hostname
+	client.cloak = client.server.cloaker.Cloak(hostname)
+	client.hostmask = client.cloak
+}
+
 func (client *Client) SetNickname(nickname Name) {
 	if client.HasNick() {
 		log.Errorf("%s nickname already set!", client)
@@ -364,14 +464,42 @@ func (client *Client) ChangeNickname(nickname Name) {
 		friend.Reply(reply)
 		return true
 	})
+	client.server.Relay(client, reply)
+}
+
+// Message delivers a message addressed to the client, passing it on to
+// the server the client is connected to if it is remote.
+func (client *Client) Message(message string) {
+	if client.link != nil {
+		client.link.Send(message)
+		return
+	}
+	client.Reply(message)
 }
 
 func (client *Client) Reply(reply string) {
 	if client.replies != nil {
-		client.replies <- reply
+		client.replies <- client.filterTags(reply)
 	}
 }
 
+// filterTags strips any message tags from reply the client has not
+// negotiated support for.
+func (client *Client) filterTags(reply string) string {
+	if !strings.HasPrefix(reply, "@") || client.HasCapability(MessageTags) {
+		return reply
+	}
+	tags, reply := SplitTags(reply)
+	kept := make(Tags)
+	if value, ok := tags["time"]; ok && client.HasCapability(ServerTime) {
+		kept["time"] = value
+	}
+	if value, ok := tags["batch"]; ok && client.HasCapability(Batch) {
+		kept["batch"] = value
+	}
+	return WithTags(reply, kept)
+}
+
 func (client *Client) Quit(message Text) {
 	if client.hasQuit {
 		return
@@ -384,11 +512,12 @@ func (client *Client) Quit(message Text) {
 	friends.Remove(client)
 	client.destroy()
 
-	if friends.Count() > 0 {
-		reply := RplQuit(client, message)
-		friends.Range(func(friend *Client) bool {
-			friend.Reply(reply)
-			return true
-		})
+	reply := RplQuit(client, message)
+	friends.Range(func(friend *Client) bool {
+		friend.Reply(reply)
+		return true
+	})
+	if client.registered {
+		client.server.Relay(client, reply)
 	}
 }
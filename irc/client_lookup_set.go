@@ -217,7 +217,8 @@ func (set *UserMaskSet) setRegexp() {
 			manyExprs[mindex] = strings.Join(oneExprs, ".")
 		}
 		maskExprs[index] = strings.Join(manyExprs, ".*")
+		index++
 	}
-	expr := "^" + strings.Join(maskExprs, "|") + "$"
+	expr := "^(?:" + strings.Join(maskExprs, "|") + ")$"
 	set.regexp, _ = regexp.Compile(expr)
 }
@@ -0,0 +1,93 @@
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_CLOAK_LABELS = 2    // domain labels of hostnames kept in cloaks
+	DEFAULT_CLOAK_SUFFIX = "IP" // suffix of cloaked IP addresses
+
+	// SAMPLE_CLOAK_SECRET is the placeholder secret of the sample config
+	SAMPLE_CLOAK_SECRET = "change-me-to-a-long-random-string"
+)
+
+// Cloaker derives the visible hosts of clients from their hostnames with
+// an HMAC keyed by a secret. Cloaks of hostnames keep their domain, such
+// as abcd1234.isp.example, and cloaks of IP addresses keep the structure
+// of their networks, such as 1a2b.3c4d.5e6f.IP, so bans can still match
+// a whole domain or network.
+type Cloaker struct {
+	secret []byte
+	labels int
+	suffix string
+}
+
+// NewCloaker returns a cloaker keyed by secret, or by a random secret if
+// it is empty in which case cloaks change when the server restarts
+func NewCloaker(secret string, labels int, suffix string) *Cloaker {
+	key := []byte(secret)
+	if secret == "" {
+		log.Warn("cloaking secret not set, cloaks will change on restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("error generating cloaking secret: %s", err)
+		}
+	}
+	return &Cloaker{
+		secret: key,
+		labels: labels,
+		suffix: suffix,
+	}
+}
+
+// hash returns the first n hex digits of the HMAC of data
+func (cloaker *Cloaker) hash(data string, n int) string {
+	mac := hmac.New(sha256.New, cloaker.secret)
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))[:n]
+}
+
+// cloakIP hashes the address and the networks it is in: the /24 and /16
+// of an IPv4 address or the /64 and /48 of an IPv6 address
+func (cloaker *Cloaker) cloakIP(ip net.IP) string {
+	prefixes, bits := []int{32, 24, 16}, 32
+	if ip.To4() == nil {
+		prefixes, bits = []int{128, 64, 48}, 128
+	}
+
+	parts := make([]string, 0, len(prefixes)+1)
+	for _, prefix := range prefixes {
+		network := ip.Mask(net.CIDRMask(prefix, bits))
+		parts = append(parts, cloaker.hash(fmt.Sprintf("%s/%d", network, prefix), 4))
+	}
+	return strings.Join(append(parts, cloaker.suffix), ".")
+}
+
+// cloakHostname hashes the hostname keeping its domain labels, but never
+// all of its labels
+func (cloaker *Cloaker) cloakHostname(hostname string) string {
+	labels := strings.Split(hostname, ".")
+	keep := cloaker.labels
+	if keep >= len(labels) {
+		keep = len(labels) - 1
+	}
+	return strings.Join(append([]string{cloaker.hash(hostname, 8)}, labels[len(labels)-keep:]...), ".")
+}
+
+// Cloak returns the cloak of a hostname or IP address
+func (cloaker *Cloaker) Cloak(hostname Name) Name {
+	host := strings.ToLower(hostname.String())
+	if ip := net.ParseIP(host); ip != nil {
+		return Name(cloaker.cloakIP(ip))
+	}
+	return Name(cloaker.cloakHostname(host))
+}
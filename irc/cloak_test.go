@@ -0,0 +1,70 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCloak(t *testing.T) {
+	cloaker := NewCloaker("secret", 2, "IP")
+
+	tests := map[Name]string{
+		"host.dsl.isp.example": ".isp.example",
+		"isp.example":          ".example",
+		"192.0.2.1":            ".IP",
+		"2001:db8::1":          ".IP",
+	}
+	for hostname, suffix := range tests {
+		cloak := cloaker.Cloak(hostname).String()
+		if !strings.HasSuffix(cloak, suffix) || strings.Contains(cloak, hostname.String()) {
+			t.Errorf("Expected the cloak of %s to end in %s, got %s", hostname, suffix, cloak)
+		}
+		if cloaker.Cloak(hostname).String() != cloak {
+			t.Errorf("Expected the cloak of %s to be stable", hostname)
+		}
+	}
+
+	// addresses in the same network share the end of their cloaks
+	a := strings.SplitN(cloaker.Cloak("192.0.2.1").String(), ".", 2)
+	b := strings.SplitN(cloaker.Cloak("192.0.2.2").String(), ".", 2)
+	if a[0] == b[0] || a[1] != b[1] {
+		t.Errorf("Expected cloaks sharing the /24, got %v and %v", a, b)
+	}
+
+	if other := NewCloaker("other", 2, "IP"); other.Cloak("192.0.2.1") == cloaker.Cloak("192.0.2.1") {
+		t.Error("Expected cloaks to depend on the secret")
+	}
+}
+
+func TestChannelMatchesCloak(t *testing.T) {
+	cloaker := NewCloaker("secret", 2, "IP")
+	client := &Client{
+		nick:     "foo",
+		username: "foo",
+		server:   &Server{cloaker: cloaker},
+	}
+	client.SetHostname("192.0.2.1")
+
+	channel := &Channel{lists: map[ChannelMode]*UserMaskSet{BanMask: NewUserMaskSet()}}
+	channel.lists[BanMask].Add("*!*@unrelated.example")
+	channel.lists[BanMask].Add(NewName("*!*@*." + strings.SplitN(client.hostmask.String(), ".", 2)[1]))
+	if !channel.Matches(BanMask, client) {
+		t.Errorf("Expected a ban on the cloaked network to match %s", client.hostmask)
+	}
+
+	channel.lists[BanMask] = NewUserMaskSet()
+	channel.lists[BanMask].Add("*!*@192.0.2.*")
+	if !channel.Matches(BanMask, client) {
+		t.Error("Expected a ban on the real host to match")
+	}
+
+	cloak := client.hostmask
+	client.SetVHost("vhost.example.org")
+	for _, mask := range []string{"*!*@" + cloak.String(), "*!*@192.0.2.1", "*!*@vhost.example.org"} {
+		channel.lists[BanMask] = NewUserMaskSet()
+		channel.lists[BanMask].Add(NewName(mask))
+		if !channel.Matches(BanMask, client) {
+			t.Errorf("Expected a ban on %s to match a client with a vhost", mask)
+		}
+	}
+}
@@ -6,13 +6,16 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Command interface {
 	Client() *Client
 	Code() StringCode
+	Tags() Tags
 	SetClient(*Client)
 	SetCode(StringCode)
+	SetTags(Tags)
 }
 
 type checkPasswordCommand interface {
@@ -25,23 +28,33 @@ type parseCommandFunc func([]string) (Command, error)
 var (
 	NotEnoughArgsError = errors.New("not enough arguments")
 	ErrParseCommand    = errors.New("failed to parse message")
+	ErrInputTooLong    = errors.New("input line too long")
 	parseCommandFuncs  = map[StringCode]parseCommandFunc{
 		AUTHENTICATE: ParseAuthenticateCommand,
 		AWAY:         ParseAwayCommand,
 		CAP:          ParseCapCommand,
+		CHANSERV:     ParseServiceMsgCommand("chanserv"),
+		CHATHISTORY:  ParseChatHistoryCommand,
+		CHGHOST:      ParseChgHostCommand,
+		CS:           ParseServiceMsgCommand("chanserv"),
+		DLINE:        ParseXLineCommand(DLine),
 		INVITE:       ParseInviteCommand,
 		ISON:         ParseIsOnCommand,
 		JOIN:         ParseJoinCommand,
 		KICK:         ParseKickCommand,
 		KILL:         ParseKillCommand,
+		KLINE:        ParseXLineCommand(KLine),
 		LIST:         ParseListCommand,
 		MODE:         ParseModeCommand,
 		MOTD:         ParseMOTDCommand,
 		NAMES:        ParseNamesCommand,
+		NICKSERV:     ParseServiceMsgCommand("nickserv"),
+		NS:           ParseServiceMsgCommand("nickserv"),
 		NICK:         ParseNickCommand,
 		NOTICE:       ParseNoticeCommand,
 		ONICK:        ParseOperNickCommand,
 		OPER:         ParseOperCommand,
+		REGISTER:     ParseRegisterCommand,
 		REHASH:       ParseRehashCommand,
 		PART:         ParsePartCommand,
 		PASS:         ParsePassCommand,
@@ -49,12 +62,18 @@ var (
 		PONG:         ParsePongCommand,
 		PRIVMSG:      ParsePrivMsgCommand,
 		QUIT:         ParseQuitCommand,
+		STATS:        ParseStatsCommand,
+		TAGMSG:       ParseTagMsgCommand,
 		TIME:         ParseTimeCommand,
 		LUSERS:       ParseLUsersCommand,
 		TOPIC:        ParseTopicCommand,
+		UNDLINE:      ParseUnXLineCommand(DLine),
+		UNKLINE:      ParseUnXLineCommand(KLine),
 		USER:         ParseUserCommand,
 		VERSION:      ParseVersionCommand,
+		VHOST:        ParseVHostCommand,
 		WALLOPS:      ParseWallopsCommand,
+		WEBIRC:       ParseWebIRCCommand,
 		WHO:          ParseWhoCommand,
 		WHOIS:        ParseWhoisCommand,
 		WHOWAS:       ParseWhoWasCommand,
@@ -64,6 +83,7 @@ var (
 type BaseCommand struct {
 	client *Client
 	code   StringCode
+	tags   Tags
 }
 
 func (command *BaseCommand) Client() *Client {
@@ -82,8 +102,26 @@ func (command *BaseCommand) SetCode(code StringCode) {
 	command.code = code
 }
 
+func (command *BaseCommand) Tags() Tags {
+	if command.tags == nil {
+		return make(Tags)
+	}
+	return command.tags
+}
+
+func (command *BaseCommand) SetTags(tags Tags) {
+	command.tags = tags
+}
+
 func ParseCommand(line string) (cmd Command, err error) {
-	code, args := ParseLine(line)
+	if strings.HasPrefix(line, "@") {
+		raw, _ := splitArg(line[len("@"):])
+		if len(raw) > MAX_TAGS_LEN {
+			return nil, ErrInputTooLong
+		}
+	}
+
+	tags, code, args := ParseLine(line)
 	constructor := parseCommandFuncs[code]
 	if constructor == nil {
 		cmd = ParseUnknownCommand(args)
@@ -92,6 +130,7 @@ func ParseCommand(line string) (cmd Command, err error) {
 	}
 	if cmd != nil {
 		cmd.SetCode(code)
+		cmd.SetTags(tags)
 	}
 	return
 }
@@ -111,8 +150,11 @@ func splitArg(line string) (arg string, rest string) {
 	return
 }
 
-func ParseLine(line string) (command StringCode, args []string) {
+// ParseLine parses a line of the form:
+// [ "@" <tags> SPACE ] [ ":" <source> SPACE ] <command> <params>
+func ParseLine(line string) (tags Tags, command StringCode, args []string) {
 	args = make([]string, 0)
+	tags, line = SplitTags(line)
 	if strings.HasPrefix(line, ":") {
 		_, line = splitArg(line)
 	}
@@ -229,6 +271,36 @@ func ParsePassCommand(args []string) (Command, error) {
 	}, nil
 }
 
+// WEBIRC <password> <gateway> <hostname> <ip> [:<options>]
+
+type WebIRCCommand struct {
+	BaseCommand
+	password string
+	gateway  string
+	hostname string
+	ip       string
+	options  map[string]bool
+}
+
+func ParseWebIRCCommand(args []string) (Command, error) {
+	if len(args) < 4 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &WebIRCCommand{
+		password: args[0],
+		gateway:  args[1],
+		hostname: args[2],
+		ip:       args[3],
+		options:  make(map[string]bool),
+	}
+	if len(args) > 4 {
+		for _, option := range strings.Fields(args[4]) {
+			cmd.options[strings.ToLower(strings.SplitN(option, "=", 2)[0])] = true
+		}
+	}
+	return cmd, nil
+}
+
 // NICK <nickname>
 
 func ParseNickCommand(args []string) (Command, error) {
@@ -403,6 +475,22 @@ func ParsePrivMsgCommand(args []string) (Command, error) {
 	}, nil
 }
 
+// TAGMSG <target>
+
+type TagMsgCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseTagMsgCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &TagMsgCommand{
+		target: NewName(args[0]),
+	}, nil
+}
+
 // TOPIC [newtopic]
 
 type TopicCommand struct {
@@ -645,22 +733,65 @@ type OperCommand struct {
 }
 
 func (msg *OperCommand) LoadPassword(server *Server) {
-	msg.hash = server.operators[msg.name]
+	if oper := server.operators[msg.name]; oper != nil {
+		msg.hash = oper.Password
+	}
 }
 
-// OPER <name> <password>
+// OPER <name> [<password>]
 func ParseOperCommand(args []string) (Command, error) {
-	if len(args) < 2 {
+	if len(args) < 1 {
 		return nil, NotEnoughArgsError
 	}
 
 	cmd := &OperCommand{
 		name: NewName(args[0]),
 	}
-	cmd.password = []byte(args[1])
+	if len(args) > 1 {
+		cmd.password = []byte(args[1])
+	}
 	return cmd, nil
 }
 
+// REGISTER <account> <email> <password>
+type RegisterCommand struct {
+	BaseCommand
+	account  string
+	email    string
+	password string
+}
+
+func ParseRegisterCommand(args []string) (Command, error) {
+	if len(args) < 3 {
+		return nil, NotEnoughArgsError
+	}
+	return &RegisterCommand{
+		account:  args[0],
+		email:    args[1],
+		password: args[2],
+	}, nil
+}
+
+// CHATHISTORY <subcommand> <target> <selector> [<selector>] <limit>
+
+type ChatHistoryCommand struct {
+	BaseCommand
+	subCommand string
+	target     Name
+	args       []string
+}
+
+func ParseChatHistoryCommand(args []string) (Command, error) {
+	if len(args) < 3 {
+		return nil, NotEnoughArgsError
+	}
+	return &ChatHistoryCommand{
+		subCommand: strings.ToUpper(args[0]),
+		target:     NewName(args[1]),
+		args:       args[2:],
+	}, nil
+}
+
 type RehashCommand struct {
 	BaseCommand
 }
@@ -673,6 +804,7 @@ func ParseRehashCommand(args []string) (Command, error) {
 type CapCommand struct {
 	BaseCommand
 	subCommand   CapSubCommand
+	arg          string
 	capabilities CapabilitySet
 }
 
@@ -687,6 +819,7 @@ func ParseCapCommand(args []string) (Command, error) {
 	}
 
 	if len(args) > 1 {
+		cmd.arg = args[1]
 		strs := spacesExpr.Split(args[1], -1)
 		for _, str := range strs {
 			cmd.capabilities[Capability(str)] = true
@@ -893,6 +1026,115 @@ func ParseKillCommand(args []string) (Command, error) {
 	}, nil
 }
 
+// KLINE [<duration>] <user@host> [:<reason>]
+// DLINE [<duration>] <ip/cidr> [:<reason>]
+
+type XLineCommand struct {
+	BaseCommand
+	kind     XLineType
+	duration time.Duration
+	mask     string
+	reason   string
+}
+
+func ParseXLineCommand(kind XLineType) parseCommandFunc {
+	return func(args []string) (Command, error) {
+		cmd := &XLineCommand{kind: kind}
+		if len(args) > 1 {
+			if duration, err := ParseBanDuration(args[0]); err == nil {
+				cmd.duration = duration
+				args = args[1:]
+			}
+		}
+		if len(args) < 1 {
+			return nil, NotEnoughArgsError
+		}
+		cmd.mask = args[0]
+		if len(args) > 1 {
+			cmd.reason = args[1]
+		}
+		return cmd, nil
+	}
+}
+
+// UNKLINE <user@host>
+// UNDLINE <ip/cidr>
+
+type UnXLineCommand struct {
+	BaseCommand
+	kind XLineType
+	mask string
+}
+
+func ParseUnXLineCommand(kind XLineType) parseCommandFunc {
+	return func(args []string) (Command, error) {
+		if len(args) < 1 {
+			return nil, NotEnoughArgsError
+		}
+		return &UnXLineCommand{kind: kind, mask: args[0]}, nil
+	}
+}
+
+// STATS <query>
+
+type StatsCommand struct {
+	BaseCommand
+	query string
+}
+
+func ParseStatsCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &StatsCommand{query: args[0]}, nil
+}
+
+// CHGHOST <nick> [<host>]
+
+type ChgHostCommand struct {
+	BaseCommand
+	nickname Name
+	vhost    Name
+}
+
+func ParseChgHostCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &ChgHostCommand{nickname: NewName(args[0])}
+	if len(args) > 1 {
+		cmd.vhost = NewName(args[1])
+	}
+	return cmd, nil
+}
+
+// VHOST SET <account> <host>
+// VHOST DEL <account>
+
+type VHostCommand struct {
+	BaseCommand
+	subCommand string
+	account    string
+	vhost      Name
+}
+
+func ParseVHostCommand(args []string) (Command, error) {
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &VHostCommand{
+		subCommand: strings.ToUpper(args[0]),
+		account:    args[1],
+	}
+	if cmd.subCommand == "SET" {
+		if len(args) < 3 {
+			return nil, NotEnoughArgsError
+		}
+		cmd.vhost = NewName(args[2])
+	}
+	return cmd, nil
+}
+
 type WallopsCommand struct {
 	BaseCommand
 	message Text
@@ -930,6 +1172,20 @@ func ParseWhoWasCommand(args []string) (Command, error) {
 	return cmd, nil
 }
 
+// <service> <command> [args...]
+func ParseServiceMsgCommand(service Name) parseCommandFunc {
+	return func(args []string) (Command, error) {
+		// services commands may be given as a single trailing argument
+		if len(args) == 1 {
+			args = spacesExpr.Split(strings.TrimSpace(args[0]), -1)
+		}
+		return &ServiceMsgCommand{
+			service: service,
+			args:    args,
+		}, nil
+	}
+}
+
 func ParseOperNickCommand(args []string) (Command, error) {
 	if len(args) < 2 {
 		return nil, NotEnoughArgsError
@@ -2,21 +2,157 @@ package irc
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"sync"
+	"net"
+	"strings"
+	"time"
 
-	"github.com/imdario/mergo"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	AccountStoreMemory   = "memory"   // accounts from the config only
+	AccountStoreDatabase = "database" // accounts persisted in the database
+)
+
 type PassConfig struct {
 	Password string
 }
 
+// OperClassConfig is a named set of operator privileges
+type OperClassConfig struct {
+	Privileges []string
+}
+
+// OperatorConfig is how a client authenticates as an operator: with a
+// password, a SASL account, a TLS client certificate or several of them,
+// optionally only from some hosts.
+type OperatorConfig struct {
+	PassConfig `yaml:",inline"`
+	Class      string   // all privileges if not set
+	Account    string   // account the client must be logged into
+	CertFP     string   // fingerprint of the client certificate required
+	Hosts      []string // user@host masks the client must match
+	AutoOper   bool     // oper on login without OPER
+}
+
 type TLSConfig struct {
-	Key  string
-	Cert string
+	Key         string
+	Cert        string
+	ClientCerts bool
+}
+
+type WebSocketConfig struct {
+	TLSConfig `yaml:",inline"`
+	Origins   []string
+}
+
+// ProxyConfig lists the listeners accepting PROXY protocol headers and
+// the proxies trusted to send them.
+type ProxyConfig struct {
+	Listeners []string
+	Trusted   []string
+}
+
+// Enabled returns true if the listener on addr accepts PROXY headers
+func (conf *ProxyConfig) Enabled(addr string) bool {
+	for _, listener := range conf.Listeners {
+		if listener == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedNets parses the trusted proxy addresses and networks
+func (conf *ProxyConfig) TrustedNets() ([]*net.IPNet, error) {
+	return ParseNetworks(conf.Trusted)
+}
+
+// WebIRCConfig is a WEBIRC gateway allowed to connect from hosts
+type WebIRCConfig struct {
+	PassConfig `yaml:",inline"`
+	Hosts      []string
+}
+
+// ParseNetworks parses a list of IP addresses and CIDR networks
+func ParseNetworks(addrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+				addr += "/32"
+			} else {
+				addr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ConnLimitConfig limits the connections of IP addresses and networks
+type ConnLimitConfig struct {
+	PerIP      int           // concurrent connections from an IP, 0 for no limit
+	PerNetwork int           // concurrent connections from a network, 0 for no limit
+	IPv4Prefix int           // prefix length of IPv4 networks
+	IPv6Prefix int           // prefix length of IPv6 networks
+	Throttle   int           // new connections from an IP per window, 0 for no limit
+	Window     time.Duration // window new connections are throttled over
+	Exempt     []string      // IPs and networks exempt from the limits
+}
+
+// IsExempt returns true if ip is exempt from the connection limits
+func (conf *ConnLimitConfig) IsExempt(ip net.IP) bool {
+	networks, err := ParseNetworks(conf.Exempt)
+	if err != nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// DNSBLConfig is a DNS blocklist and the action taken against the
+// clients listed in it
+type DNSBLConfig struct {
+	Action  string   // reject, mark or sasl
+	Reason  string   // shown to rejected clients
+	Replies []string // replies counting as listed, any if empty
+}
+
+// FloodConfig limits the rate of commands of each client with a token
+// bucket. Commands cost one token unless set otherwise in Costs.
+type FloodConfig struct {
+	Burst  int            // tokens a client starts with
+	Rate   float64        // tokens refilled per second, 0 disables limiting
+	MaxLag time.Duration  // how far behind a client may fall before Excess Flood
+	Costs  map[string]int // tokens taken by commands
+}
+
+// Cost returns the number of tokens a command takes
+func (conf *FloodConfig) Cost(code StringCode) int {
+	if cost, ok := conf.Costs[code.String()]; ok {
+		return cost
+	}
+	return 1
+}
+
+type LinkConfig struct {
+	Address  string
+	Password string
+	Connect  bool
+	TLS      bool   // connect over TLS and refuse the server without it
+	CertFP   string // fingerprint of the TLS certificate of the server
 }
 
 func (conf *PassConfig) PasswordBytes() []byte {
@@ -28,7 +164,6 @@ func (conf *PassConfig) PasswordBytes() []byte {
 }
 
 type Config struct {
-	sync.Mutex
 	filename string
 
 	Network struct {
@@ -36,27 +171,115 @@ type Config struct {
 	}
 
 	Server struct {
-		PassConfig  `yaml:",inline"`
-		Listen      []string
-		TLSListen   map[string]*TLSConfig
-		Log         string
-		MOTD        string
-		Name        string
-		Description string
+		PassConfig      `yaml:",inline"`
+		Listen          []string
+		TLSListen       map[string]*TLSConfig
+		WebSocketListen map[string]*WebSocketConfig
+		LinkListen      []string
+		TLSLinkListen   map[string]*TLSConfig
+		Proxy           ProxyConfig
+		Log             string
+		MOTD            string
+		Name            string
+		Description     string
+		Database        string
+		AccountStore    string
+	}
+
+	Registration struct {
+		Disabled bool
+	}
+
+	NickReservation struct {
+		Enforce string
+		Timeout time.Duration
 	}
 
-	Operator map[string]*PassConfig
-	Account  map[string]*PassConfig
+	History struct {
+		Size       int
+		Persistent bool
+	}
+
+	Flood       FloodConfig
+	Connections ConnLimitConfig
+
+	Cloaking struct {
+		Secret string            // HMAC key, shared by linked servers
+		Labels int               // domain labels of hostnames kept visible
+		Suffix string            // suffix of cloaked IP addresses
+		VHosts map[string]string // vhosts of accounts replacing their cloaks
+	}
+
+	DNSBL struct {
+		Resolver string
+		Timeout  time.Duration
+		Lists    map[string]*DNSBLConfig
+	}
+
+	Audit struct {
+		File    string // file audit entries are appended to as JSON lines
+		Notices bool   // send audit entries to operators with +s
+	}
+
+	OperClass map[string]*OperClassConfig
+	Operator  map[string]*OperatorConfig
+	Account   map[string]*PassConfig
+	Link      map[string]*LinkConfig
+	WebIRC    map[string]*WebIRCConfig
 }
 
-func (conf *Config) Operators() map[Name][]byte {
-	operators := make(map[Name][]byte)
+func (conf *Config) Operators() map[Name]*Oper {
+	operators := make(map[Name]*Oper)
 	for name, opConf := range conf.Operator {
-		operators[NewName(name)] = opConf.PasswordBytes()
+		var privileges []OperPrivilege
+		if classConf := conf.OperClass[opConf.Class]; classConf != nil {
+			for _, privilege := range classConf.Privileges {
+				privileges = append(privileges, OperPrivilege(privilege))
+			}
+		}
+
+		oper := &Oper{
+			Name:     NewName(name),
+			Class:    NewOperClass(opConf.Class, privileges),
+			Account:  CanonicalAccountName(opConf.Account),
+			CertFP:   strings.ToLower(opConf.CertFP),
+			AutoOper: opConf.AutoOper,
+		}
+		if opConf.Password != "" {
+			oper.Password = opConf.PasswordBytes()
+		}
+		if len(opConf.Hosts) > 0 {
+			oper.Hosts = NewUserMaskSet()
+			for _, host := range opConf.Hosts {
+				if !strings.Contains(host, "!") {
+					host = "*!" + host
+				}
+				oper.Hosts.Add(NewName(strings.ToLower(host)))
+			}
+		}
+		operators[oper.Name] = oper
 	}
 	return operators
 }
 
+// NewCloaker returns the configured cloaker
+func (conf *Config) NewCloaker() *Cloaker {
+	return NewCloaker(conf.Cloaking.Secret, conf.Cloaking.Labels, conf.Cloaking.Suffix)
+}
+
+// NewAuditLog returns the configured audit log
+func (conf *Config) NewAuditLog() (*AuditLog, error) {
+	return NewAuditLog(conf.Audit.File, conf.Audit.Notices)
+}
+
+// NewDNSBL returns the configured DNS blocklists, or nil if there are none
+func (conf *Config) NewDNSBL() *DNSBL {
+	if len(conf.DNSBL.Lists) == 0 {
+		return nil
+	}
+	return NewDNSBL(NewDNSBLResolver(conf.DNSBL.Resolver), conf.DNSBL.Timeout, conf.DNSBL.Lists)
+}
+
 func (conf *Config) Accounts() map[string][]byte {
 	accounts := make(map[string][]byte)
 	for name, account := range conf.Account {
@@ -69,21 +292,11 @@ func (conf *Config) Name() string {
 	return conf.filename
 }
 
-func (conf *Config) Reload() error {
-	conf.Lock()
-	defer conf.Unlock()
-
-	newconf, err := LoadConfig(conf.filename)
-	if err != nil {
-		return nil
-	}
-
-	err = mergo.MergeWithOverwrite(conf, newconf)
-	if err != nil {
-		return nil
-	}
-
-	return nil
+// Reload loads the config file again. The new config is returned rather
+// than merged into conf, so a rehash can prepare everything it needs
+// before applying it, and blocks removed from the file are gone.
+func (conf *Config) Reload() (*Config, error) {
+	return LoadConfig(conf.filename)
 }
 
 func LoadConfig(filename string) (config *Config, err error) {
@@ -111,9 +324,184 @@ func LoadConfig(filename string) (config *Config, err error) {
 		return nil, errors.New("Server name must match the format of a hostname")
 	}
 
-	if len(config.Server.Listen)+len(config.Server.TLSListen) == 0 {
+	if len(config.Server.Listen)+len(config.Server.TLSListen)+
+		len(config.Server.WebSocketListen) == 0 {
 		return nil, errors.New("Server listening addresses missing")
 	}
 
+	switch config.Server.AccountStore {
+	case "":
+		if config.Server.Database != "" {
+			config.Server.AccountStore = AccountStoreDatabase
+		} else {
+			config.Server.AccountStore = AccountStoreMemory
+		}
+	case AccountStoreMemory:
+	case AccountStoreDatabase:
+		if config.Server.Database == "" {
+			return nil, errors.New("Account store database requires a database filename")
+		}
+	default:
+		return nil, errors.New("Account store must be one of memory or database")
+	}
+
+	switch config.NickReservation.Enforce {
+	case "", NickEnforceReject, NickEnforceRename, NickEnforceKill:
+	default:
+		return nil, errors.New("Nick reservation enforcement must be one of reject, rename or kill")
+	}
+
+	if _, err := config.Server.Proxy.TrustedNets(); err != nil {
+		return nil, fmt.Errorf("Invalid trusted proxy: %s", err)
+	}
+	if len(config.Server.Proxy.Listeners) > 0 && len(config.Server.Proxy.Trusted) == 0 {
+		return nil, errors.New("PROXY protocol listeners require trusted proxies")
+	}
+
+	for name, gateway := range config.WebIRC {
+		if _, err := DecodePassword(gateway.Password); err != nil {
+			return nil, fmt.Errorf("WEBIRC gateway %s password invalid: %s", name, err)
+		}
+		if len(gateway.Hosts) == 0 {
+			return nil, fmt.Errorf("WEBIRC gateway %s hosts missing", name)
+		}
+		if _, err := ParseNetworks(gateway.Hosts); err != nil {
+			return nil, fmt.Errorf("WEBIRC gateway %s hosts invalid: %s", name, err)
+		}
+	}
+
+	if config.History.Persistent && config.Server.Database == "" {
+		return nil, errors.New("Persistent history requires a database filename")
+	}
+
+	if config.Flood.Rate < 0 || config.Flood.Burst < 0 || config.Flood.MaxLag < 0 {
+		return nil, errors.New("Flood burst, rate and maxlag must not be negative")
+	}
+	costs := make(map[string]int)
+	for command, cost := range config.Flood.Costs {
+		if cost < 0 {
+			return nil, fmt.Errorf("Flood cost of %s must not be negative", command)
+		}
+		costs[strings.ToUpper(command)] = cost
+	}
+	config.Flood.Costs = costs
+	if config.Flood.Burst == 0 {
+		config.Flood.Burst = DEFAULT_FLOOD_BURST
+	}
+	if config.Flood.MaxLag == 0 {
+		config.Flood.MaxLag = DEFAULT_FLOOD_MAXLAG
+	}
+
+	limits := &config.Connections
+	if limits.PerIP < 0 || limits.PerNetwork < 0 || limits.Throttle < 0 || limits.Window < 0 {
+		return nil, errors.New("Connection limits must not be negative")
+	}
+	if limits.IPv4Prefix < 0 || limits.IPv4Prefix > 32 ||
+		limits.IPv6Prefix < 0 || limits.IPv6Prefix > 128 {
+		return nil, errors.New("Connection limit prefix lengths invalid")
+	}
+	if _, err := ParseNetworks(limits.Exempt); err != nil {
+		return nil, fmt.Errorf("Connection limit exemptions invalid: %s", err)
+	}
+	if limits.IPv4Prefix == 0 {
+		limits.IPv4Prefix = DEFAULT_IPV4_PREFIX
+	}
+	if limits.IPv6Prefix == 0 {
+		limits.IPv6Prefix = DEFAULT_IPV6_PREFIX
+	}
+	if limits.Window == 0 {
+		limits.Window = DEFAULT_THROTTLE_WINDOW
+	}
+
+	for name, class := range config.OperClass {
+		if len(class.Privileges) == 0 {
+			return nil, fmt.Errorf("Operator class %s privileges missing", name)
+		}
+		for _, privilege := range class.Privileges {
+			if !IsOperPrivilege(privilege) {
+				return nil, fmt.Errorf("Operator class %s privilege %s unknown", name, privilege)
+			}
+		}
+	}
+	for name, opConf := range config.Operator {
+		if opConf.Class != "" && config.OperClass[opConf.Class] == nil {
+			return nil, fmt.Errorf("Operator %s class %s missing", name, opConf.Class)
+		}
+		if opConf.Password == "" && opConf.Account == "" && opConf.CertFP == "" {
+			return nil, fmt.Errorf("Operator %s requires a password, account or certfp", name)
+		}
+		if opConf.Password != "" {
+			if _, err := DecodePassword(opConf.Password); err != nil {
+				return nil, fmt.Errorf("Operator %s password invalid: %s", name, err)
+			}
+		}
+		if opConf.AutoOper && opConf.Account == "" && opConf.CertFP == "" {
+			return nil, fmt.Errorf("Operator %s autooper requires an account or certfp", name)
+		}
+	}
+
+	if config.Cloaking.Secret == SAMPLE_CLOAK_SECRET {
+		return nil, errors.New("Cloaking secret must be changed from the sample value")
+	}
+	if config.Cloaking.Labels <= 0 {
+		config.Cloaking.Labels = DEFAULT_CLOAK_LABELS
+	}
+	if config.Cloaking.Suffix == "" {
+		config.Cloaking.Suffix = DEFAULT_CLOAK_SUFFIX
+	}
+	vhosts := make(map[string]string)
+	for account, vhost := range config.Cloaking.VHosts {
+		if !IsHostname(vhost) {
+			return nil, fmt.Errorf("VHost %s of account %s must match the format of a hostname", vhost, account)
+		}
+		vhosts[CanonicalAccountName(account)] = vhost
+	}
+	config.Cloaking.VHosts = vhosts
+
+	if config.DNSBL.Resolver != "" {
+		if _, _, err := net.SplitHostPort(config.DNSBL.Resolver); err != nil {
+			config.DNSBL.Resolver = net.JoinHostPort(config.DNSBL.Resolver, "53")
+		}
+	}
+	if config.DNSBL.Timeout <= 0 {
+		config.DNSBL.Timeout = DEFAULT_DNSBL_TIMEOUT
+	}
+	for zone, list := range config.DNSBL.Lists {
+		if _, ok := dnsblActions[list.Action]; !ok {
+			return nil, fmt.Errorf("DNSBL %s action must be one of reject, mark or sasl", zone)
+		}
+		for _, reply := range list.Replies {
+			if net.ParseIP(reply) == nil {
+				return nil, fmt.Errorf("DNSBL %s reply %s is not an IP address", zone, reply)
+			}
+		}
+		if list.Reason == "" {
+			list.Reason = fmt.Sprintf("Your address is listed in %s", zone)
+		}
+	}
+
+	for name, link := range config.Link {
+		if !IsHostname(name) {
+			return nil, fmt.Errorf("Link name %s must match the format of a hostname", name)
+		}
+		if link.Password == "" {
+			return nil, fmt.Errorf("Link %s password missing", name)
+		}
+		if link.Connect && link.Address == "" {
+			return nil, fmt.Errorf("Link %s address missing", name)
+		}
+		if link.CertFP != "" {
+			link.CertFP = strings.ToLower(link.CertFP)
+			if !IsCertFP(link.CertFP) {
+				return nil, fmt.Errorf("Link %s certfp is not a SHA-256 fingerprint", name)
+			}
+			link.TLS = true
+		}
+	}
+
+	if config.NickReservation.Timeout == 0 {
+		config.NickReservation.Timeout = DEFAULT_NICK_TIMEOUT
+	}
+
 	return config, nil
 }
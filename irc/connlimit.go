@@ -0,0 +1,145 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_IPV4_PREFIX     = 24          // size of the IPv4 networks connections are limited by
+	DEFAULT_IPV6_PREFIX     = 64          // size of the IPv6 networks connections are limited by
+	DEFAULT_THROTTLE_WINDOW = time.Minute // window new connections are throttled over
+)
+
+var (
+	ErrConnLimitIP      = errors.New("Too many connections from your IP address")
+	ErrConnLimitNetwork = errors.New("Too many connections from your network")
+	ErrConnThrottled    = errors.New("Too many connections too quickly, try again later")
+
+	// labels of the rejected connections metric
+	connLimitLabels = map[error]string{
+		ErrConnLimitIP:      "ip",
+		ErrConnLimitNetwork: "network",
+		ErrConnThrottled:    "throttle",
+	}
+)
+
+type connWindow struct {
+	start time.Time
+	count int
+}
+
+// ConnLimiter counts the connections from each IP address and network
+// and rejects connections over the configured limits. Connections from
+// exempt addresses are counted but never rejected.
+type ConnLimiter struct {
+	sync.Mutex
+	config   *ConnLimitConfig
+	ips      map[string]int
+	networks map[string]int
+	windows  map[string]*connWindow
+	swept    time.Time
+}
+
+func NewConnLimiter(config *ConnLimitConfig) *ConnLimiter {
+	return &ConnLimiter{
+		config:   config,
+		ips:      make(map[string]int),
+		networks: make(map[string]int),
+		windows:  make(map[string]*connWindow),
+	}
+}
+
+// network returns the network ip is limited by
+func (limiter *ConnLimiter) network(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		prefix := limiter.config.IPv4Prefix
+		return fmt.Sprintf("%s/%d", ip4.Mask(net.CIDRMask(prefix, 32)), prefix)
+	}
+	prefix := limiter.config.IPv6Prefix
+	return fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(prefix, 128)), prefix)
+}
+
+// throttle counts a new connection from ip at now and returns true if
+// there have been too many in the current window
+func (limiter *ConnLimiter) throttle(ip net.IP, now time.Time) bool {
+	window := limiter.config.Window
+	if now.Sub(limiter.swept) > window {
+		for key, w := range limiter.windows {
+			if now.Sub(w.start) > window {
+				delete(limiter.windows, key)
+			}
+		}
+		limiter.swept = now
+	}
+
+	key := ip.String()
+	w, ok := limiter.windows[key]
+	if !ok || now.Sub(w.start) > window {
+		w = &connWindow{start: now}
+		limiter.windows[key] = w
+	}
+	w.count++
+	return w.count > limiter.config.Throttle
+}
+
+// SetConfig replaces the limits, keeping the connections counted
+func (limiter *ConnLimiter) SetConfig(config *ConnLimitConfig) {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	limiter.config = config
+}
+
+// Add counts a new connection from ip at now, or returns the limit it
+// is over
+func (limiter *ConnLimiter) Add(ip net.IP, now time.Time) error {
+	if ip == nil {
+		return nil
+	}
+
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	config := limiter.config
+	network := limiter.network(ip)
+	if !config.IsExempt(ip) {
+		if config.Throttle > 0 && limiter.throttle(ip, now) {
+			return ErrConnThrottled
+		}
+		if config.PerIP > 0 && limiter.ips[ip.String()] >= config.PerIP {
+			return ErrConnLimitIP
+		}
+		if config.PerNetwork > 0 && limiter.networks[network] >= config.PerNetwork {
+			return ErrConnLimitNetwork
+		}
+	}
+
+	limiter.ips[ip.String()]++
+	limiter.networks[network]++
+	return nil
+}
+
+// Remove stops counting a closed connection from ip
+func (limiter *ConnLimiter) Remove(ip net.IP) {
+	if ip == nil {
+		return
+	}
+
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	decrement(limiter.ips, ip.String())
+	decrement(limiter.networks, limiter.network(ip))
+}
+
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+	} else {
+		counts[key]--
+	}
+}
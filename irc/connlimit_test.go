@@ -0,0 +1,81 @@
+package irc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter(t *testing.T) {
+	limiter := NewConnLimiter(&ConnLimitConfig{
+		PerIP:      2,
+		PerNetwork: 3,
+		IPv4Prefix: 24,
+		IPv6Prefix: 64,
+		Exempt:     []string{"127.0.0.1"},
+	})
+	now := time.Now()
+
+	a, b := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	for _, ip := range []net.IP{a, a, b} {
+		if err := limiter.Add(ip, now); err != nil {
+			t.Fatalf("Expected %s to connect, got %s", ip, err)
+		}
+	}
+	if err := limiter.Add(a, now); err != ErrConnLimitIP {
+		t.Errorf("Expected ErrConnLimitIP, got %v", err)
+	}
+	if err := limiter.Add(b, now); err != ErrConnLimitNetwork {
+		t.Errorf("Expected ErrConnLimitNetwork, got %v", err)
+	}
+	if err := limiter.Add(net.ParseIP("198.51.100.1"), now); err != nil {
+		t.Errorf("Expected another network to connect, got %s", err)
+	}
+
+	limiter.Remove(a)
+	if err := limiter.Add(b, now); err != nil {
+		t.Errorf("Expected %s to connect after a disconnect, got %s", b, err)
+	}
+
+	exempt := net.ParseIP("127.0.0.1")
+	for i := 0; i < 5; i++ {
+		if err := limiter.Add(exempt, now); err != nil {
+			t.Errorf("Expected exempt address to connect, got %s", err)
+		}
+	}
+
+	v6 := net.ParseIP("2001:db8::1")
+	limiter.Add(v6, now)
+	limiter.Add(net.ParseIP("2001:db8::2"), now)
+	limiter.Add(net.ParseIP("2001:db8::3"), now)
+	if err := limiter.Add(net.ParseIP("2001:db8::4"), now); err != ErrConnLimitNetwork {
+		t.Errorf("Expected ErrConnLimitNetwork for the /64, got %v", err)
+	}
+}
+
+func TestConnLimiterThrottle(t *testing.T) {
+	limiter := NewConnLimiter(&ConnLimitConfig{
+		IPv4Prefix: 24,
+		IPv6Prefix: 64,
+		Throttle:   2,
+		Window:     time.Minute,
+	})
+	now := time.Now()
+
+	ip := net.ParseIP("192.0.2.1")
+	for i := 0; i < 2; i++ {
+		if err := limiter.Add(ip, now); err != nil {
+			t.Fatalf("Expected connection %d to be allowed, got %s", i, err)
+		}
+		limiter.Remove(ip)
+	}
+	if err := limiter.Add(ip, now.Add(time.Second)); err != ErrConnThrottled {
+		t.Errorf("Expected ErrConnThrottled, got %v", err)
+	}
+	if err := limiter.Add(ip, now.Add(2*time.Minute)); err != nil {
+		t.Errorf("Expected a connection after the window to be allowed, got %s", err)
+	}
+	if len(limiter.windows) != 1 {
+		t.Errorf("Expected expired windows to be swept, got %d", len(limiter.windows))
+	}
+}
@@ -4,37 +4,60 @@ const (
 	CRLF          = "\r\n"
 	MAX_REPLY_LEN = 512 - len(CRLF)
 
+	// maximum number of tokens sent in a single RPL_ISUPPORT
+	MAX_ISUPPORT_TOKENS = 13
+
 	// string codes
+	ACCOUNT      StringCode = "ACCOUNT"
 	AUTHENTICATE StringCode = "AUTHENTICATE" // SASL
 	AWAY         StringCode = "AWAY"
+	BATCH        StringCode = "BATCH"
 	CAP          StringCode = "CAP"
+	CHANSERV     StringCode = "CHANSERV"
+	CHATHISTORY  StringCode = "CHATHISTORY"
+	CHGHOST      StringCode = "CHGHOST"
+	CS           StringCode = "CS"
+	DLINE        StringCode = "DLINE"
 	ERROR        StringCode = "ERROR"
+	FAIL         StringCode = "FAIL"
 	INVITE       StringCode = "INVITE"
 	ISON         StringCode = "ISON"
 	JOIN         StringCode = "JOIN"
 	KICK         StringCode = "KICK"
 	KILL         StringCode = "KILL"
+	KLINE        StringCode = "KLINE"
 	LIST         StringCode = "LIST"
 	MODE         StringCode = "MODE"
 	MOTD         StringCode = "MOTD"
 	NAMES        StringCode = "NAMES"
 	NICK         StringCode = "NICK"
+	NICKSERV     StringCode = "NICKSERV"
+	NS           StringCode = "NS"
 	NOTICE       StringCode = "NOTICE"
 	ONICK        StringCode = "ONICK"
 	OPER         StringCode = "OPER"
+	REGISTER     StringCode = "REGISTER"
 	REHASH       StringCode = "REHASH"
+	SERVER       StringCode = "SERVER"
+	SQUIT        StringCode = "SQUIT"
+	STATS        StringCode = "STATS"
 	PART         StringCode = "PART"
 	PASS         StringCode = "PASS"
 	PING         StringCode = "PING"
 	PONG         StringCode = "PONG"
 	PRIVMSG      StringCode = "PRIVMSG"
 	QUIT         StringCode = "QUIT"
+	TAGMSG       StringCode = "TAGMSG"
 	TIME         StringCode = "TIME"
 	LUSERS       StringCode = "LUSERS"
 	TOPIC        StringCode = "TOPIC"
+	UNDLINE      StringCode = "UNDLINE"
+	UNKLINE      StringCode = "UNKLINE"
 	USER         StringCode = "USER"
 	VERSION      StringCode = "VERSION"
+	VHOST        StringCode = "VHOST"
 	WALLOPS      StringCode = "WALLOPS"
+	WEBIRC       StringCode = "WEBIRC"
 	WHO          StringCode = "WHO"
 	WHOIS        StringCode = "WHOIS"
 	WHOWAS       StringCode = "WHOWAS"
@@ -45,6 +68,7 @@ const (
 	RPL_CREATED           NumericCode = 3
 	RPL_MYINFO            NumericCode = 4
 	RPL_BOUNCE            NumericCode = 5
+	RPL_ISUPPORT          NumericCode = 5
 	RPL_TRACELINK         NumericCode = 200
 	RPL_TRACECONNECTING   NumericCode = 201
 	RPL_TRACEHANDSHAKE    NumericCode = 202
@@ -58,8 +82,10 @@ const (
 	RPL_TRACERECONNECT    NumericCode = 210
 	RPL_STATSLINKINFO     NumericCode = 211
 	RPL_STATSCOMMANDS     NumericCode = 212
+	RPL_STATSKLINE        NumericCode = 216
 	RPL_ENDOFSTATS        NumericCode = 219
 	RPL_UMODEIS           NumericCode = 221
+	RPL_STATSDLINE        NumericCode = 225
 	RPL_SERVLIST          NumericCode = 234
 	RPL_SERVLISTEND       NumericCode = 235
 	RPL_STATSUPTIME       NumericCode = 242
@@ -76,6 +102,7 @@ const (
 	RPL_TRACELOG          NumericCode = 261
 	RPL_TRACEEND          NumericCode = 262
 	RPL_TRYAGAIN          NumericCode = 263
+	RPL_WHOISCERTFP       NumericCode = 276
 	RPL_AWAY              NumericCode = 301
 	RPL_USERHOST          NumericCode = 302
 	RPL_ISON              NumericCode = 303
@@ -89,6 +116,7 @@ const (
 	RPL_WHOISIDLE         NumericCode = 317
 	RPL_ENDOFWHOIS        NumericCode = 318
 	RPL_WHOISCHANNELS     NumericCode = 319
+	RPL_WHOISSPECIAL      NumericCode = 320
 	RPL_LIST              NumericCode = 322
 	RPL_LISTEND           NumericCode = 323
 	RPL_CHANNELMODEIS     NumericCode = 324
@@ -124,6 +152,7 @@ const (
 	RPL_USERS             NumericCode = 393
 	RPL_ENDOFUSERS        NumericCode = 394
 	RPL_NOUSERS           NumericCode = 395
+	RPL_HOSTHIDDEN        NumericCode = 396
 	ERR_NOSUCHNICK        NumericCode = 401
 	ERR_NOSUCHSERVER      NumericCode = 402
 	ERR_NOSUCHCHANNEL     NumericCode = 403
@@ -139,6 +168,7 @@ const (
 	ERR_NOTOPLEVEL        NumericCode = 413
 	ERR_WILDTOPLEVEL      NumericCode = 414
 	ERR_BADMASK           NumericCode = 415
+	ERR_INPUTTOOLONG      NumericCode = 417
 	ERR_UNKNOWNCOMMAND    NumericCode = 421
 	ERR_NOMOTD            NumericCode = 422
 	ERR_NOADMININFO       NumericCode = 423
@@ -0,0 +1,43 @@
+package irc
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// buckets created when the database is opened
+	databaseBuckets = []string{
+		"accounts",
+		"certfps",
+		"channels",
+		"history",
+		"vhosts",
+		"xlines",
+	}
+)
+
+// OpenDatabase opens (creating if necessary) the BoltDB database at path
+// and ensures all buckets used by the server exist.
+func OpenDatabase(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range databaseBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,166 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_DNSBL_TIMEOUT = 5 * time.Second // how long blocklist lookups may take
+
+	// actions taken against clients listed in a blocklist, strongest last
+	DNSBLMark        = "mark"   // show the listing to operators
+	DNSBLRequireSASL = "sasl"   // only allow clients logged in with SASL
+	DNSBLReject      = "reject" // disconnect the client
+)
+
+var (
+	dnsblActions = map[string]int{DNSBLMark: 1, DNSBLRequireSASL: 2, DNSBLReject: 3}
+)
+
+// DNSBLResolver looks up the addresses of DNS names, *net.Resolver
+// being the usual implementation.
+type DNSBLResolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// NewDNSBLResolver returns a resolver querying the DNS server at addr,
+// or the system resolver if addr is empty
+func NewDNSBLResolver(addr string) DNSBLResolver {
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// DNSBLListing is a blocklist an address is listed in
+type DNSBLListing struct {
+	Zone   string
+	Action string
+	Reason string
+}
+
+// DNSBL looks addresses up in DNS blocklists
+type DNSBL struct {
+	resolver DNSBLResolver
+	timeout  time.Duration
+	lists    map[string]*DNSBLConfig
+}
+
+func NewDNSBL(resolver DNSBLResolver, timeout time.Duration, lists map[string]*DNSBLConfig) *DNSBL {
+	return &DNSBL{
+		resolver: resolver,
+		timeout:  timeout,
+		lists:    lists,
+	}
+}
+
+// DNSBLQuery returns the name to look up to find ip in the blocklist
+// zone: the reversed octets of an IPv4 address or the reversed nibbles
+// of an IPv6 address, followed by the zone.
+func DNSBLQuery(ip net.IP, zone string) string {
+	labels := make([]string, 0, 32)
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%d", ip4[i]))
+		}
+	} else {
+		ip6 := ip.To16()
+		for i := len(ip6) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%x", ip6[i]&0x0f), fmt.Sprintf("%x", ip6[i]>>4))
+		}
+	}
+	return strings.Join(labels, ".") + "." + strings.TrimSuffix(zone, ".") + "."
+}
+
+// listed returns true if ip is listed in zone
+func (dnsbl *DNSBL) listed(ctx context.Context, ip net.IP, zone string, list *DNSBLConfig) bool {
+	replies, err := dnsbl.resolver.LookupIP(ctx, "ip4", DNSBLQuery(ip, zone))
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+			log.Debugf("DNSBL lookup of %s in %s failed: %s", ip, zone, err)
+		}
+		return false
+	}
+	if len(list.Replies) == 0 {
+		return len(replies) > 0
+	}
+	for _, reply := range replies {
+		for _, expected := range list.Replies {
+			if reply.Equal(net.ParseIP(expected)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lookup looks ip up in all blocklists at once and returns the listing
+// with the strongest action, or nil if ip is not listed
+func (dnsbl *DNSBL) Lookup(ip net.IP) *DNSBLListing {
+	if dnsbl == nil || ip == nil || len(dnsbl.lists) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsbl.timeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var listing *DNSBLListing
+	var wg sync.WaitGroup
+	for zone, list := range dnsbl.lists {
+		wg.Add(1)
+		go func(zone string, list *DNSBLConfig) {
+			defer wg.Done()
+			if !dnsbl.listed(ctx, ip, zone, list) {
+				return
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if listing == nil || dnsblActions[list.Action] > dnsblActions[listing.Action] {
+				listing = &DNSBLListing{Zone: zone, Action: list.Action, Reason: list.Reason}
+			}
+		}(zone, list)
+	}
+	wg.Wait()
+
+	return listing
+}
+
+// applyDNSBL takes the action of the blocklist the client is listed in,
+// returning false if the client was disconnected
+func (client *Client) applyDNSBL(listing *DNSBLListing) bool {
+	client.dnsbl = listing
+	if listing == nil {
+		return true
+	}
+
+	log.Infof("%s: %s is listed in %s (%s)", client, client.ip, listing.Zone, listing.Action)
+	client.server.metrics.CounterVec("client", "dnsbl_listings").
+		WithLabelValues(listing.Zone, listing.Action).Inc()
+
+	switch listing.Action {
+	case DNSBLReject:
+		client.ErrYoureBannedCreep(listing.Reason)
+		client.Quit("DNSBL")
+		return false
+
+	case DNSBLRequireSASL:
+		client.Reply(RplNotice(client.server, client, NewText(
+			fmt.Sprintf("%s. You must log in with SASL to connect.", listing.Reason))))
+	}
+	return true
+}
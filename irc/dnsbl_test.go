@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubDNSServer answers A queries for the names in records and NXDOMAIN
+// for any other name.
+func stubDNSServer(t *testing.T, records map[string]string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+
+			// question name, type and class
+			labels := []string{}
+			offset := 12
+			for offset < n && buf[offset] != 0 {
+				length := int(buf[offset])
+				labels = append(labels, string(buf[offset+1:offset+1+length]))
+				offset += 1 + length
+			}
+			offset += 5
+			if offset > n {
+				continue
+			}
+			name := strings.ToLower(strings.Join(labels, "."))
+			qtype := binary.BigEndian.Uint16(buf[offset-4:])
+
+			reply := append([]byte{}, buf[:offset]...)
+			binary.BigEndian.PutUint16(reply[6:], 0)  // answers
+			binary.BigEndian.PutUint16(reply[8:], 0)  // authorities
+			binary.BigEndian.PutUint16(reply[10:], 0) // additional
+			ip, ok := records[name]
+			if !ok {
+				binary.BigEndian.PutUint16(reply[2:], 0x8183) // NXDOMAIN
+			} else {
+				binary.BigEndian.PutUint16(reply[2:], 0x8180)
+				if qtype == 1 {
+					binary.BigEndian.PutUint16(reply[6:], 1)
+					reply = append(reply,
+						0xc0, 12, // name
+						0, 1, 0, 1, // A IN
+						0, 0, 0, 60, // TTL
+						0, 4)
+					reply = append(reply, net.ParseIP(ip).To4()...)
+				}
+			}
+			conn.WriteTo(reply, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDNSBLQuery(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":   "1.2.0.192.dnsbl.example.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.dnsbl.example.",
+	}
+	for ip, expected := range tests {
+		if query := DNSBLQuery(net.ParseIP(ip), "dnsbl.example."); query != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, ip, query)
+		}
+	}
+}
+
+func TestDNSBLLookup(t *testing.T) {
+	addr := stubDNSServer(t, map[string]string{
+		"1.2.0.192.mark.example":   "127.0.0.2",
+		"1.2.0.192.sasl.example":   "127.0.0.3",
+		"2.2.0.192.sasl.example":   "127.0.0.3",
+		"2.2.0.192.reject.example": "127.0.0.4",
+		"3.2.0.192.reject.example": "127.0.0.2",
+	})
+	dnsbl := NewDNSBL(NewDNSBLResolver(addr), time.Second, map[string]*DNSBLConfig{
+		"mark.example":   {Action: DNSBLMark},
+		"sasl.example":   {Action: DNSBLRequireSASL},
+		"reject.example": {Action: DNSBLReject, Replies: []string{"127.0.0.4"}},
+	})
+
+	tests := map[string]string{
+		"192.0.2.1": "sasl.example",
+		"192.0.2.2": "reject.example",
+		"192.0.2.3": "", // reply not listed for the zone
+		"192.0.2.4": "",
+	}
+	for ip, expected := range tests {
+		listing := dnsbl.Lookup(net.ParseIP(ip))
+		if expected == "" {
+			if listing != nil {
+				t.Errorf("Expected %s not to be listed, got %s", ip, listing.Zone)
+			}
+		} else if listing == nil || listing.Zone != expected {
+			t.Errorf("Expected %s to be listed in %s, got %v", ip, expected, listing)
+		}
+	}
+
+	var nilDNSBL *DNSBL
+	if listing := nilDNSBL.Lookup(net.ParseIP("192.0.2.1")); listing != nil {
+		t.Errorf("Expected no listing without blocklists, got %v", listing)
+	}
+}
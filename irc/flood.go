@@ -0,0 +1,101 @@
+package irc
+
+import (
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_FLOOD_BURST  = 10               // commands a client may send at once
+	DEFAULT_FLOOD_MAXLAG = 10 * time.Second // how far behind a client may fall
+)
+
+// FloodLimiter is a token bucket limiting the rate of commands of a
+// client. Commands beyond the burst are delayed (fake lag) until enough
+// tokens have refilled, and a client that falls more than maxLag behind
+// is flooding.
+type FloodLimiter struct {
+	burst  float64
+	rate   float64
+	maxLag time.Duration
+	tokens float64
+	last   time.Time
+}
+
+// NewFloodLimiter returns a full bucket of burst tokens refilling at
+// rate tokens per second
+func NewFloodLimiter(burst int, rate float64, maxLag time.Duration) *FloodLimiter {
+	return &FloodLimiter{
+		burst:  float64(burst),
+		rate:   rate,
+		maxLag: maxLag,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Take takes cost tokens at now and returns how long the command must
+// be delayed, and false if that is more than maxLag.
+func (limiter *FloodLimiter) Take(now time.Time, cost int) (time.Duration, bool) {
+	if elapsed := now.Sub(limiter.last).Seconds(); elapsed > 0 {
+		limiter.tokens = math.Min(limiter.burst, limiter.tokens+elapsed*limiter.rate)
+		limiter.last = now
+	}
+
+	limiter.tokens -= float64(cost)
+	if limiter.tokens >= 0 {
+		return 0, true
+	}
+
+	lag := time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+	return lag, lag <= limiter.maxLag
+}
+
+// lineCode returns the code of command, or of line if it failed to parse
+func lineCode(command Command, line string) StringCode {
+	if command != nil {
+		return command.Code()
+	}
+	_, code, _ := ParseLine(line)
+	return code
+}
+
+// commandLabel returns the metrics label of code, which is "unknown"
+// for anything but known commands so clients cannot create labels
+func commandLabel(code StringCode) string {
+	if parseCommandFuncs[code] == nil {
+		return "unknown"
+	}
+	return code.String()
+}
+
+// throttle delays the command with code of a flooding client, and
+// disconnects the client and returns false if it has flooded. Operators
+// are exempt.
+func (client *Client) throttle(code StringCode) bool {
+	config := client.server.Config().Flood
+	if config.Rate <= 0 || client.HasFlag(Operator) {
+		return true
+	}
+
+	if client.flood == nil {
+		client.flood = NewFloodLimiter(config.Burst, config.Rate, config.MaxLag)
+	}
+
+	lag, ok := client.flood.Take(time.Now(), config.Cost(code))
+	if !ok {
+		log.Infof("%s: excess flood (%s behind)", client, lag)
+		client.server.metrics.Counter("client", "excess_floods").Inc()
+		client.Quit("Excess Flood")
+		return false
+	}
+
+	if lag > 0 {
+		client.server.metrics.CounterVec("client", "throttled_commands").
+			WithLabelValues(commandLabel(code)).Inc()
+		time.Sleep(lag)
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package irc
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestFloodLimiter(t *testing.T) {
+	limiter := NewFloodLimiter(3, 2, time.Second)
+	now := limiter.last
+
+	for i := 0; i < 3; i++ {
+		if lag, ok := limiter.Take(now, 1); lag != 0 || !ok {
+			t.Errorf("Expected command %d of the burst not to lag, got %s", i, lag)
+		}
+	}
+	if lag, ok := limiter.Take(now, 1); lag != 500*time.Millisecond || !ok {
+		t.Errorf("Expected 500ms of lag, got %s (%v)", lag, ok)
+	}
+
+	// the delayed command refills the token it took
+	now = now.Add(500 * time.Millisecond)
+	if lag, ok := limiter.Take(now, 2); lag != time.Second || !ok {
+		t.Errorf("Expected 1s of lag, got %s (%v)", lag, ok)
+	}
+	if lag, ok := limiter.Take(now, 1); lag != 1500*time.Millisecond || ok {
+		t.Errorf("Expected excess flood after 1.5s of lag, got %s (%v)", lag, ok)
+	}
+
+	// the bucket never holds more than the burst
+	now = now.Add(time.Hour)
+	if _, ok := limiter.Take(now, 3); !ok || limiter.tokens != 0 {
+		t.Errorf("Expected the bucket to refill to 3 tokens, got %g left", limiter.tokens)
+	}
+}
+
+func TestFloodConfigCost(t *testing.T) {
+	config := FloodConfig{Costs: map[string]int{"WHO": 3, "PONG": 0}}
+	for code, expected := range map[StringCode]int{WHO: 3, PONG: 0, PRIVMSG: 1} {
+		if cost := config.Cost(code); cost != expected {
+			t.Errorf("Expected %s to cost %d, got %d", code, expected, cost)
+		}
+	}
+}
+
+func TestClientThrottleParseErrors(t *testing.T) {
+	server := newTestNickServer("")
+	server.metrics.metrics["client_excess_floods"] = prometheus.NewCounter(
+		prometheus.CounterOpts{Name: "excess_floods"},
+	)
+	server.cloaker = NewCloaker("secret", 2, "IP")
+	server.config.Flood = FloodConfig{Burst: 2, Rate: 1, MaxLag: time.Millisecond}
+
+	client := newTestNickClient(server, "foo")
+	client.registered = false
+	conn, remote := net.Pipe()
+	client.socket = NewSocket(conn)
+
+	done := make(chan bool)
+	go func() {
+		client.readloop()
+		close(done)
+	}()
+
+	long := "@" + strings.Repeat("a", MAX_TAGS_LEN+1) + " PING x\r\n"
+	for i := 0; i < 3; i++ {
+		if _, err := remote.Write([]byte(long)); err != nil {
+			break
+		}
+	}
+	defer remote.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected lines that fail to parse to count against the flood limit")
+	}
+}
+
+func TestCommandLabel(t *testing.T) {
+	for code, expected := range map[StringCode]string{PRIVMSG: "PRIVMSG", "FOOBAR": "unknown", "": "unknown"} {
+		if label := commandLabel(code); label != expected {
+			t.Errorf("Expected %q to be labelled %s, got %s", code, expected, label)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package irc
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	CHATHISTORY_MAX = 100 // maximum number of messages sent per CHATHISTORY
+)
+
+var (
+	ErrHistorySelector = errors.New("invalid message reference")
+
+	msgIdEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// NewMsgId returns a new random message id (msgid tag)
+func NewMsgId() string {
+	id := make([]byte, 10)
+	if _, err := rand.Read(id); err != nil {
+		log.Errorf("error generating message id: %s", err)
+	}
+	return strings.ToLower(msgIdEncoding.EncodeToString(id))
+}
+
+// HistoryItem is a message kept in the history of a channel or of a
+// private conversation.
+type HistoryItem struct {
+	MsgId   string     `json:"msgid"`
+	Time    time.Time  `json:"time"`
+	Source  string     `json:"source"`
+	Command StringCode `json:"command"`
+	Target  string     `json:"target"`
+	Message string     `json:"message"`
+	Tags    Tags       `json:"tags,omitempty"`
+}
+
+// NewHistoryItem returns an item for a message client is sending now.
+// tags are the tags sent with the message, of which the client-only tags
+// are kept. Messages of remote clients keep the msgid and time given by
+// the server they were sent to, so they are the same across the network.
+func NewHistoryItem(client *Client, command StringCode, target Name,
+	message Text, tags Tags) *HistoryItem {
+	item := &HistoryItem{
+		MsgId: NewMsgId(),
+		// truncated to the precision of the time tag so timestamps
+		// sent back by clients refer to the same instant
+		Time:    time.Now().UTC().Truncate(time.Millisecond),
+		Source:  client.Id().String(),
+		Command: command,
+		Target:  target.String(),
+		Message: message.String(),
+		Tags:    tags.ClientOnly(),
+	}
+	if client.link != nil {
+		if msgid := tags["msgid"]; msgid != "" {
+			item.MsgId = msgid
+		}
+		if t, err := time.Parse(ServerTimeFormat, tags["time"]); err == nil {
+			item.Time = t
+		}
+	}
+	return item
+}
+
+// MessageTags returns the tags sent with the message
+func (item *HistoryItem) MessageTags() Tags {
+	tags := Tags{
+		"msgid": item.MsgId,
+		"time":  FormatServerTime(item.Time),
+	}
+	for key, value := range item.Tags {
+		tags[key] = value
+	}
+	return tags
+}
+
+// Line returns the message as it was originally sent
+func (item *HistoryItem) Line() string {
+	return WithTags(
+		fmt.Sprintf(":%s %s %s :%s", item.Source, item.Command, item.Target, item.Message),
+		item.MessageTags(),
+	)
+}
+
+// HistoryStore keeps the most recent messages of each target, oldest
+// first. Targets are channel names or conversation keys (see
+// dmHistoryKey).
+type HistoryStore interface {
+	Add(target string, item *HistoryItem) error
+	Get(target string) ([]*HistoryItem, error)
+	Delete(target string) error
+}
+
+// historyBuffer is a fixed size ring buffer of history items
+type historyBuffer struct {
+	items []*HistoryItem
+	start int
+	count int
+}
+
+func (buffer *historyBuffer) add(item *HistoryItem) {
+	if buffer.count < len(buffer.items) {
+		buffer.items[(buffer.start+buffer.count)%len(buffer.items)] = item
+		buffer.count++
+		return
+	}
+	buffer.items[buffer.start] = item
+	buffer.start = (buffer.start + 1) % len(buffer.items)
+}
+
+func (buffer *historyBuffer) all() []*HistoryItem {
+	items := make([]*HistoryItem, buffer.count)
+	for i := range items {
+		items[i] = buffer.items[(buffer.start+i)%len(buffer.items)]
+	}
+	return items
+}
+
+// MemoryHistoryStore keeps history for the lifetime of the server only
+type MemoryHistoryStore struct {
+	sync.RWMutex
+	size    int
+	buffers map[string]*historyBuffer
+}
+
+func NewMemoryHistoryStore(size int) *MemoryHistoryStore {
+	return &MemoryHistoryStore{
+		size:    size,
+		buffers: make(map[string]*historyBuffer),
+	}
+}
+
+func (store *MemoryHistoryStore) Add(target string, item *HistoryItem) error {
+	store.Lock()
+	defer store.Unlock()
+
+	buffer, ok := store.buffers[target]
+	if !ok {
+		buffer = &historyBuffer{items: make([]*HistoryItem, store.size)}
+		store.buffers[target] = buffer
+	}
+	buffer.add(item)
+	return nil
+}
+
+func (store *MemoryHistoryStore) Get(target string) ([]*HistoryItem, error) {
+	store.RLock()
+	defer store.RUnlock()
+
+	buffer, ok := store.buffers[target]
+	if !ok {
+		return nil, nil
+	}
+	return buffer.all(), nil
+}
+
+func (store *MemoryHistoryStore) Delete(target string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	delete(store.buffers, target)
+	return nil
+}
+
+// BoltHistoryStore keeps history in the "history" bucket of a BoltDB
+// database with a nested bucket for each target.
+type BoltHistoryStore struct {
+	db   *bolt.DB
+	size int
+}
+
+func NewBoltHistoryStore(db *bolt.DB, size int) *BoltHistoryStore {
+	return &BoltHistoryStore{db: db, size: size}
+}
+
+func (store *BoltHistoryStore) Add(target string, item *HistoryItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	// Batch coalesces the messages of concurrent clients into a single
+	// transaction, and so a single sync to disk.
+	return store.db.Batch(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket([]byte("history")).CreateBucketIfNotExists([]byte(target))
+		if err != nil {
+			return err
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := bucket.Put(key, data); err != nil {
+			return err
+		}
+
+		// drop the oldest items beyond the size of the store
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.First() {
+			if seq-binary.BigEndian.Uint64(k) < uint64(store.size) {
+				break
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *BoltHistoryStore) Get(target string) ([]*HistoryItem, error) {
+	items := make([]*HistoryItem, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("history")).Bucket([]byte(target))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, value []byte) error {
+			item := &HistoryItem{}
+			if err := json.Unmarshal(value, item); err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+	return items, err
+}
+
+func (store *BoltHistoryStore) Delete(target string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("history")).DeleteBucket([]byte(target))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// HistorySelector refers to a message in a CHATHISTORY command by
+// msgid or timestamp. The zero value is the "*" wildcard.
+type HistorySelector struct {
+	MsgId string
+	Time  time.Time
+}
+
+// ParseHistorySelector parses *, msgid=<msgid> or timestamp=<timestamp>
+func ParseHistorySelector(selector string) (HistorySelector, error) {
+	parts := strings.SplitN(selector, "=", 2)
+	switch {
+	case selector == "*":
+		return HistorySelector{}, nil
+	case len(parts) != 2 || parts[1] == "":
+		return HistorySelector{}, ErrHistorySelector
+	case parts[0] == "msgid":
+		return HistorySelector{MsgId: parts[1]}, nil
+	case parts[0] == "timestamp":
+		t, err := time.Parse(time.RFC3339Nano, parts[1])
+		if err != nil {
+			return HistorySelector{}, ErrHistorySelector
+		}
+		return HistorySelector{Time: t}, nil
+	}
+	return HistorySelector{}, ErrHistorySelector
+}
+
+func (selector HistorySelector) IsWildcard() bool {
+	return selector.MsgId == "" && selector.Time.IsZero()
+}
+
+// bounds returns the indexes such that items[:before] are the items
+// before the selected message and items[after:] those after it. ok is
+// false if the selected message is not in items.
+func (selector HistorySelector) bounds(items []*HistoryItem) (before int, after int, ok bool) {
+	if selector.MsgId != "" {
+		for index, item := range items {
+			if item.MsgId == selector.MsgId {
+				return index, index + 1, true
+			}
+		}
+		return 0, 0, false
+	}
+
+	before = sort.Search(len(items), func(i int) bool {
+		return !items[i].Time.Before(selector.Time)
+	})
+	after = sort.Search(len(items), func(i int) bool {
+		return items[i].Time.After(selector.Time)
+	})
+	return before, after, true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// SelectHistory returns at most limit of items, oldest first, selected
+// by a CHATHISTORY subcommand. second is only used by BETWEEN.
+func SelectHistory(items []*HistoryItem, subCommand string,
+	first HistorySelector, second HistorySelector, limit int) []*HistoryItem {
+	if first.IsWildcard() && subCommand == "LATEST" {
+		return items[maxInt(0, len(items)-limit):]
+	}
+
+	before, after, ok := first.bounds(items)
+	if !ok {
+		return nil
+	}
+
+	switch subCommand {
+	case "LATEST":
+		items = items[after:]
+		return items[maxInt(0, len(items)-limit):]
+
+	case "BEFORE":
+		return items[maxInt(0, before-limit):before]
+
+	case "AFTER":
+		return items[after:minInt(len(items), after+limit)]
+
+	case "AROUND":
+		start := maxInt(0, before-limit/2)
+		end := minInt(len(items), start+limit)
+		return items[maxInt(0, end-limit):end]
+
+	case "BETWEEN":
+		before2, after2, ok := second.bounds(items)
+		if !ok {
+			return nil
+		}
+		if before <= before2 {
+			// forwards from first
+			items = items[after:maxInt(after, before2)]
+			return items[:minInt(len(items), limit)]
+		}
+		// backwards from first
+		items = items[after2:maxInt(after2, before)]
+		return items[maxInt(0, len(items)-limit):]
+	}
+	return nil
+}
+
+// historyName is the name private conversations of the client are kept
+// under: its account.
+func (client *Client) historyName() string {
+	if client.sasl.Id() == "" {
+		return ""
+	}
+	return CanonicalAccountName(client.sasl.Id())
+}
+
+// dmHistoryKey returns the key of the private conversation between
+// two accounts.
+func dmHistoryKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + " " + b
+}
+
+// AddHistory adds item to the history of target if history is enabled
+func (server *Server) AddHistory(target string, item *HistoryItem) {
+	if server.history == nil {
+		return
+	}
+	if err := server.history.Add(target, item); err != nil {
+		log.Errorf("error adding history for %s: %s", target, err)
+	}
+}
+
+// DeleteHistory drops the history of target if history is enabled
+func (server *Server) DeleteHistory(target string) {
+	if server.history == nil {
+		return
+	}
+	if err := server.history.Delete(target); err != nil {
+		log.Errorf("error deleting history for %s: %s", target, err)
+	}
+}
+
+// AddPrivateHistory adds item to the history of the conversation
+// between client and target. Private conversations are only kept if
+// both clients are logged in so history never passes to another user
+// of a nickname.
+func (server *Server) AddPrivateHistory(client *Client, target *Client, item *HistoryItem) {
+	if client.historyName() == "" || target.historyName() == "" {
+		return
+	}
+	server.AddHistory(dmHistoryKey(client.historyName(), target.historyName()), item)
+}
+
+// newBatchId returns a new reference tag for a BATCH
+func newBatchId() string {
+	return NewMsgId()[:8]
+}
+
+//
+// commands
+//
+
+func (msg *ChatHistoryCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	context := msg.subCommand
+
+	if server.history == nil {
+		client.Reply(RplFail(client, CHATHISTORY, "UNKNOWN_COMMAND", context,
+			"Message history is disabled"))
+		return
+	}
+
+	switch msg.subCommand {
+	case "LATEST", "BEFORE", "AFTER", "AROUND", "BETWEEN":
+	default:
+		client.Reply(RplFail(client, CHATHISTORY, "UNKNOWN_COMMAND", context,
+			"Unknown subcommand"))
+		return
+	}
+
+	nselectors := 1
+	if msg.subCommand == "BETWEEN" {
+		nselectors = 2
+	}
+	if len(msg.args) != nselectors+1 {
+		client.Reply(RplFail(client, CHATHISTORY, "INVALID_PARAMS", context,
+			"Wrong number of parameters"))
+		return
+	}
+
+	selectors := make([]HistorySelector, 2)
+	for i := 0; i < nselectors; i++ {
+		selector, err := ParseHistorySelector(msg.args[i])
+		if err != nil || (selector.IsWildcard() && msg.subCommand != "LATEST") {
+			client.Reply(RplFail(client, CHATHISTORY, "INVALID_PARAMS", context,
+				"Invalid message reference"))
+			return
+		}
+		selectors[i] = selector
+	}
+
+	limit, err := strconv.Atoi(msg.args[nselectors])
+	if err != nil || limit < 1 {
+		client.Reply(RplFail(client, CHATHISTORY, "INVALID_PARAMS", context,
+			"Invalid limit"))
+		return
+	}
+	limit = minInt(limit, CHATHISTORY_MAX)
+
+	var key string
+	if msg.target.IsChannel() {
+		channel := server.channels.Get(msg.target)
+		if channel == nil || !channel.members.Has(client) {
+			client.Reply(RplFail(client, CHATHISTORY, "INVALID_TARGET",
+				context+" "+msg.target.String(), "Not a member of that channel"))
+			return
+		}
+		key = channel.name.ToLower().String()
+	} else {
+		name := CanonicalAccountName(msg.target.String())
+		if target := server.clients.Get(msg.target); target != nil {
+			name = target.historyName()
+		}
+		if client.historyName() == "" || name == "" {
+			client.Reply(RplFail(client, CHATHISTORY, "INVALID_TARGET",
+				context+" "+msg.target.String(),
+				"Private history is only kept between logged in users"))
+			return
+		}
+		key = dmHistoryKey(client.historyName(), name)
+	}
+
+	items, err := server.history.Get(key)
+	if err != nil {
+		log.Errorf("error loading history for %s: %s", key, err)
+		client.Reply(RplFail(client, CHATHISTORY, "MESSAGE_ERROR", context,
+			"Error loading history"))
+		return
+	}
+
+	client.ReplyBatch("chathistory "+msg.target.String(),
+		SelectHistory(items, msg.subCommand, selectors[0], selectors[1], limit))
+}
+
+// ReplyBatch sends the messages of items to the client in a batch of
+// the given type and parameters if it supports batches.
+func (client *Client) ReplyBatch(batch string, items []*HistoryItem) {
+	if !client.HasCapability(Batch) {
+		for _, item := range items {
+			client.Reply(item.Line())
+		}
+		return
+	}
+
+	id := newBatchId()
+	client.Reply(RplBatchStart(client, id, batch))
+	for _, item := range items {
+		client.Reply(WithTags(item.Line(), Tags{"batch": id}))
+	}
+	client.Reply(RplBatchEnd(client, id))
+}
+
+func RplBatchStart(client *Client, id string, batch string) string {
+	return NewStringReply(client.server, BATCH, "+%s %s", id, batch)
+}
+
+func RplBatchEnd(client *Client, id string) string {
+	return NewStringReply(client.server, BATCH, "-%s", id)
+}
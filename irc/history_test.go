@@ -0,0 +1,247 @@
+package irc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testHistoryItems(n int) []*HistoryItem {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := make([]*HistoryItem, n)
+	for i := range items {
+		items[i] = &HistoryItem{
+			MsgId:   fmt.Sprintf("id%d", i),
+			Time:    start.Add(time.Duration(i) * time.Second),
+			Message: fmt.Sprint(i),
+		}
+	}
+	return items
+}
+
+func historyMessages(items []*HistoryItem) []string {
+	messages := make([]string, len(items))
+	for i, item := range items {
+		messages[i] = item.Message
+	}
+	return messages
+}
+
+func TestSelectHistory(t *testing.T) {
+	items := testHistoryItems(10)
+	msgid := func(id string) HistorySelector {
+		return HistorySelector{MsgId: id}
+	}
+	timestamp := func(s string) HistorySelector {
+		selector, err := ParseHistorySelector("timestamp=" + s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return selector
+	}
+
+	tests := []struct {
+		subCommand string
+		first      HistorySelector
+		second     HistorySelector
+		limit      int
+		expected   []string
+	}{
+		{"LATEST", HistorySelector{}, HistorySelector{}, 3, []string{"7", "8", "9"}},
+		{"LATEST", msgid("id7"), HistorySelector{}, 5, []string{"8", "9"}},
+		{"BEFORE", msgid("id5"), HistorySelector{}, 2, []string{"3", "4"}},
+		{"BEFORE", timestamp("2020-01-01T00:00:01.000Z"), HistorySelector{}, 5, []string{"0"}},
+		{"AFTER", msgid("id5"), HistorySelector{}, 2, []string{"6", "7"}},
+		{"AFTER", timestamp("2020-01-01T00:00:07.500Z"), HistorySelector{}, 5, []string{"8", "9"}},
+		{"AROUND", msgid("id5"), HistorySelector{}, 3, []string{"4", "5", "6"}},
+		{"AROUND", msgid("id0"), HistorySelector{}, 3, []string{"0", "1", "2"}},
+		{"BETWEEN", msgid("id2"), msgid("id6"), 10, []string{"3", "4", "5"}},
+		{"BETWEEN", msgid("id6"), msgid("id2"), 2, []string{"4", "5"}},
+		{"BETWEEN", msgid("id2"), msgid("id6"), 2, []string{"3", "4"}},
+		{"BEFORE", msgid("unknown"), HistorySelector{}, 5, []string{}},
+	}
+
+	for _, test := range tests {
+		selected := historyMessages(SelectHistory(items, test.subCommand,
+			test.first, test.second, test.limit))
+		if !reflect.DeepEqual(selected, test.expected) {
+			t.Errorf("%s %v %v %d: expected %v, got %v", test.subCommand,
+				test.first, test.second, test.limit, test.expected, selected)
+		}
+	}
+}
+
+func TestParseHistorySelector(t *testing.T) {
+	for _, selector := range []string{"msgid=", "timestamp=yesterday", "foo=bar", "id1"} {
+		if _, err := ParseHistorySelector(selector); err != ErrHistorySelector {
+			t.Errorf("Expected ErrHistorySelector for %q, got %v", selector, err)
+		}
+	}
+	if selector, err := ParseHistorySelector("*"); err != nil || !selector.IsWildcard() {
+		t.Errorf("Expected a wildcard, got %v %v", selector, err)
+	}
+}
+
+func TestMemoryHistoryStore(t *testing.T) {
+	store := NewMemoryHistoryStore(3)
+	for _, item := range testHistoryItems(5) {
+		store.Add("#test", item)
+	}
+
+	items, err := store.Get("#test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"2", "3", "4"}
+	if messages := historyMessages(items); !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %v, got %v", expected, messages)
+	}
+
+	if items, _ := store.Get("#other"); len(items) != 0 {
+		t.Errorf("Expected no history, got %d items", len(items))
+	}
+
+	store.Delete("#test")
+	if _, ok := store.buffers["#test"]; ok {
+		t.Error("Expected the buffer of #test to be evicted")
+	}
+}
+
+func TestBoltHistoryStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(filepath.Join(dir, "eris.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewBoltHistoryStore(db, 3)
+	for _, item := range testHistoryItems(5) {
+		if err := store.Add("#test", item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := store.Get("#test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"2", "3", "4"}
+	if messages := historyMessages(items); !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %v, got %v", expected, messages)
+	}
+	if !items[0].Time.Equal(testHistoryItems(3)[2].Time) || items[0].MsgId != "id2" {
+		t.Errorf("Unexpected item %+v", items[0])
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := store.Delete("#test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if items, _ := store.Get("#test"); len(items) != 0 {
+		t.Errorf("Expected the history of #test to be deleted, got %d items", len(items))
+	}
+}
+
+func TestChannelHistoryDeleted(t *testing.T) {
+	server := newTestNickServer("")
+	server.channels = NewChannelNameMap()
+	server.channelStore = NewMemoryChannelStore()
+	server.history = NewMemoryHistoryStore(10)
+
+	client := newTestNickClient(server, "alice")
+	for _, name := range []Name{"#registered", "#unregistered"} {
+		channel := NewChannel(server, name, true)
+		if name == "#registered" {
+			channel.Register("alice")
+		}
+		channel.members.Add(client)
+		server.history.Add(name.String(), testHistoryItems(1)[0])
+		channel.Quit(client)
+	}
+
+	if items, _ := server.history.Get("#unregistered"); len(items) != 0 {
+		t.Error("Expected the history of an unregistered channel to be deleted once empty")
+	}
+	if items, _ := server.history.Get("#registered"); len(items) != 1 {
+		t.Error("Expected the history of a registered channel to be kept")
+	}
+}
+
+func TestChannelPlayback(t *testing.T) {
+	server := newTestNickServer("")
+	server.channels = NewChannelNameMap()
+	server.channelStore = NewMemoryChannelStore()
+	server.history = NewMemoryHistoryStore(10)
+
+	join := func(nick, name Name, batch bool) []string {
+		client := newTestNickClient(server, nick)
+		client.capabilities = make(CapabilitySet)
+		client.replies = make(chan string, 20)
+		client.SetCapability(Batch, batch)
+		client.SetCapability(ServerTime, true)
+
+		channel := server.channels.Get(name)
+		if channel == nil {
+			channel = NewChannel(server, name, true)
+			channel.playback = 2
+		}
+		channel.Join(client, "")
+
+		var replies []string
+		for len(client.replies) > 0 {
+			reply := <-client.replies
+			if strings.Contains(reply, " PRIVMSG ") || strings.Contains(reply, " BATCH ") {
+				replies = append(replies, reply)
+			}
+		}
+		return replies
+	}
+
+	if replies := join("alice", "#empty", true); len(replies) != 0 {
+		t.Errorf("Expected no batch without history, got %q", replies)
+	}
+
+	for _, item := range testHistoryItems(3) {
+		item.Source = "dave!user@host.test"
+		item.Command = PRIVMSG
+		item.Target = "#test"
+		server.history.Add("#test", item)
+	}
+
+	replies := join("bob", "#test", true)
+	if len(replies) != 4 ||
+		!strings.Contains(replies[0], " BATCH +") || !strings.HasSuffix(replies[0], " chathistory #test") ||
+		!strings.Contains(replies[3], " BATCH -") {
+		t.Fatalf("Expected 2 messages in a chathistory batch, got %q", replies)
+	}
+	for i, reply := range replies[1:3] {
+		item := fmt.Sprintf("time=2020-01-01T00:00:0%d.000Z", i+1)
+		if !strings.Contains(reply, item) || !strings.Contains(reply, "batch=") ||
+			!strings.HasSuffix(reply, fmt.Sprintf(" PRIVMSG #test :%d", i+1)) {
+			t.Errorf("Unexpected playback %q", reply)
+		}
+	}
+
+	replies = join("carol", "#test", false)
+	if len(replies) != 2 {
+		t.Fatalf("Expected 2 messages without a batch, got %q", replies)
+	}
+	for i, reply := range replies {
+		if strings.Contains(reply, "batch=") ||
+			!strings.Contains(reply, fmt.Sprintf("time=2020-01-01T00:00:0%d.000Z", i+1)) {
+			t.Errorf("Unexpected playback %q", reply)
+		}
+	}
+}
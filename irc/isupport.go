@@ -0,0 +1,118 @@
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ISupportList holds the RPL_ISUPPORT (005) tokens a server advertises
+// to clients after registration.
+type ISupportList struct {
+	tokens map[string]string
+}
+
+// NewISupportList returns a new empty *ISupportList
+func NewISupportList() *ISupportList {
+	return &ISupportList{tokens: make(map[string]string)}
+}
+
+// Add adds a token with the given value
+func (il *ISupportList) Add(name, value string) {
+	il.tokens[name] = value
+}
+
+// AddNoValue adds a token without a value
+func (il *ISupportList) AddNoValue(name string) {
+	il.tokens[name] = ""
+}
+
+// Tokens returns the tokens in the form NAME or NAME=VALUE sorted by name
+func (il *ISupportList) Tokens() []string {
+	tokens := make([]string, 0, len(il.tokens))
+	for name, value := range il.tokens {
+		if value == "" {
+			tokens = append(tokens, name)
+		} else {
+			tokens = append(tokens, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// Equal returns true if both lists hold the same tokens and values
+func (il *ISupportList) Equal(other *ISupportList) bool {
+	if other == nil || len(il.tokens) != len(other.tokens) {
+		return false
+	}
+	for name, value := range il.tokens {
+		if ovalue, ok := other.tokens[name]; !ok || ovalue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// splitISupportTokens splits tokens into lines of at most
+// MAX_ISUPPORT_TOKENS tokens each, where every line fits within
+// MAX_REPLY_LEN given the length of the rest of the reply.
+func splitISupportTokens(tokens []string, baseLen int) [][]string {
+	return splitTokens(tokens, baseLen, MAX_ISUPPORT_TOKENS)
+}
+
+// chanModesToken returns the value of the CHANMODES token with
+// SupportedChannelModes grouped by the type of argument they take:
+// lists, always an argument, an argument only when set, and flags.
+func chanModesToken() string {
+	var lists, always, onSet, flags ChannelModes
+	for _, mode := range SupportedChannelModes {
+		switch mode {
+		case BanMask, ExceptMask, InviteMask:
+			lists = append(lists, mode)
+		case Key:
+			always = append(always, mode)
+		case UserLimit:
+			onSet = append(onSet, mode)
+		default:
+			flags = append(flags, mode)
+		}
+	}
+	return strings.Join([]string{
+		lists.String(), always.String(), onSet.String(), flags.String(),
+	}, ",")
+}
+
+func (server *Server) setISupport() {
+	isupport := NewISupportList()
+
+	// Names are compared with Name.ToLower() after NFKC normalization
+	isupport.Add("CASEMAPPING", "rfc8265")
+	isupport.Add("CHANMODES", chanModesToken())
+	isupport.Add("CHANNELLEN", fmt.Sprint(MaxChannelLength))
+	isupport.Add("CHANTYPES", ChannelTypes)
+	isupport.Add("NICKLEN", fmt.Sprint(MaxNicknameLength))
+	isupport.Add("PREFIX", fmt.Sprintf(
+		"(%s%s)@+", ChannelOperator, Voice,
+	))
+
+	for _, mode := range SupportedChannelModes {
+		switch mode {
+		case ExceptMask:
+			isupport.Add("EXCEPTS", mode.String())
+		case InviteMask:
+			isupport.Add("INVEX", mode.String())
+		}
+	}
+
+	if server.history != nil {
+		isupport.Add("CHATHISTORY", fmt.Sprint(CHATHISTORY_MAX))
+		isupport.Add("MSGREFTYPES", "timestamp,msgid")
+	}
+
+	if server.network != "" {
+		isupport.Add("NETWORK", server.network.String())
+	}
+
+	server.isupport = isupport
+}
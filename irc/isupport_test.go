@@ -0,0 +1,58 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestISupportTokens(t *testing.T) {
+	isupport := NewISupportList()
+	isupport.Add("NICKLEN", "32")
+	isupport.AddNoValue("EXCEPTS")
+	isupport.Add("CHANTYPES", "#&")
+
+	expected := "CHANTYPES=#& EXCEPTS NICKLEN=32"
+	if actual := strings.Join(isupport.Tokens(), " "); actual != expected {
+		t.Errorf("Expected tokens %q, got %q", expected, actual)
+	}
+
+	other := NewISupportList()
+	other.Add("NICKLEN", "32")
+	other.AddNoValue("EXCEPTS")
+	if isupport.Equal(other) {
+		t.Error("Expected lists with different tokens not to be equal")
+	}
+
+	other.Add("CHANTYPES", "#&")
+	if !isupport.Equal(other) {
+		t.Error("Expected lists with the same tokens to be equal")
+	}
+}
+
+func TestSplitISupportTokens(t *testing.T) {
+	tokens := make([]string, 40)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("TOKEN%02d=%s", i, strings.Repeat("x", 30))
+	}
+
+	baseLen := 60
+	lines := splitISupportTokens(tokens, baseLen)
+
+	count := 0
+	for _, line := range lines {
+		if len(line) > MAX_ISUPPORT_TOKENS {
+			t.Errorf("Expected at most %d tokens per line, got %d",
+				MAX_ISUPPORT_TOKENS, len(line))
+		}
+		if baseLen+joinedLen(line)+1 > MAX_REPLY_LEN {
+			t.Errorf("Expected line to fit within %d bytes: %v",
+				MAX_REPLY_LEN, line)
+		}
+		count += len(line)
+	}
+
+	if count != len(tokens) {
+		t.Errorf("Expected %d tokens in total, got %d", len(tokens), count)
+	}
+}
@@ -0,0 +1,904 @@
+package irc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	LINK_RETRY   = 30 * time.Second // how long to wait before reconnecting a link
+	LINK_TIMEOUT = 30 * time.Second // how long the handshake may take
+	LINK_PING    = 60 * time.Second // how often a link is pinged
+	LINK_IDLE    = 3 * LINK_PING    // how long a link may be silent before it is dropped
+	LINK_QUEUE   = 1024             // number of lines queued for a link
+)
+
+var (
+	ErrLinkExists       = errors.New("server already exists")
+	ErrLinkNotFound     = errors.New("no link block for server")
+	ErrLinkPasswd       = errors.New("bad link password")
+	ErrLinkHandshake    = errors.New("invalid link handshake")
+	ErrLinkUnexpected   = errors.New("unexpected server name")
+	ErrLinkNotConnected = errors.New("link not connected")
+	ErrLinkInsecure     = errors.New("link requires TLS")
+	ErrLinkCertFP       = errors.New("certificate fingerprint mismatch")
+)
+
+// linkCommands are the client commands relayed between servers and
+// executed on behalf of remote clients
+var linkCommands = map[StringCode]bool{
+	JOIN:    true,
+	KICK:    true,
+	MODE:    true,
+	NOTICE:  true,
+	PART:    true,
+	PRIVMSG: true,
+	QUIT:    true,
+	TAGMSG:  true,
+	TOPIC:   true,
+}
+
+// RemoteServer is a server on the network reached through a link
+type RemoteServer struct {
+	name        Name
+	description string
+	hops        uint
+	uplink      Name  // server that introduced it
+	link        *Link // directly linked server it is reached through
+}
+
+func (remote *RemoteServer) Id() Name {
+	return remote.name
+}
+
+func (remote *RemoteServer) Nick() Name {
+	return remote.name
+}
+
+func (remote *RemoteServer) String() string {
+	return remote.name.String()
+}
+
+// Link is a connection to a directly linked server. Changes made by
+// clients on either side are sent across as the lines broadcast to
+// channel members (e.g. ":nick!user@host JOIN #channel") and replayed
+// on behalf of the remote client.
+type Link struct {
+	*RemoteServer
+	server *Server
+	socket *Socket
+	sends  chan string
+	done   chan bool
+	once   sync.Once
+	reason string // why the link was dropped, set before done is closed
+
+	// pseudo client used to apply changes made by remote servers
+	operator *Client
+}
+
+func NewLink(server *Server, socket *Socket, name Name, description string) *Link {
+	link := &Link{
+		server: server,
+		socket: socket,
+		sends:  make(chan string, LINK_QUEUE),
+		done:   make(chan bool),
+	}
+	link.RemoteServer = &RemoteServer{
+		name:        name,
+		description: description,
+		hops:        1,
+		uplink:      server.name,
+		link:        link,
+	}
+	link.operator = &Client{
+		capabilities: make(CapabilitySet),
+		channels:     NewChannelSet(),
+		flags:        map[UserMode]bool{Operator: true},
+		link:         link,
+		nick:         name,
+		registered:   true,
+		sasl:         NewSaslState(),
+		server:       server,
+	}
+	return link
+}
+
+// Send queues line to be sent to the linked server. A link too far
+// behind to queue any more lines is dropped rather than blocking the
+// sender, which may be holding locks every other client needs.
+func (link *Link) Send(line string) {
+	select {
+	case link.sends <- line:
+	case <-link.done:
+	default:
+		log.Warnf("%s: send queue full, dropping link", link)
+		link.Drop("SendQ exceeded")
+	}
+}
+
+// Drop closes the link giving reason as the cause of the split
+func (link *Link) Drop(reason string) {
+	link.once.Do(func() {
+		link.reason = reason
+		close(link.done)
+		if link.socket != nil {
+			link.socket.conn.Close()
+		}
+	})
+}
+
+func (link *Link) Close() {
+	link.Drop("")
+}
+
+func (link *Link) writeloop() {
+	ping := time.NewTicker(LINK_PING)
+	defer ping.Stop()
+
+	for {
+		var line string
+		select {
+		case line = <-link.sends:
+		case <-ping.C:
+			line = RplPing(link.server)
+		case <-link.done:
+			return
+		}
+		if err := link.socket.Write(line); err != nil {
+			link.Close()
+			return
+		}
+	}
+}
+
+func (link *Link) readloop() string {
+	for {
+		link.socket.conn.SetReadDeadline(time.Now().Add(LINK_IDLE))
+		line, err := link.socket.Read()
+		if err != nil {
+			select {
+			case <-link.done:
+				if link.reason != "" {
+					return link.reason
+				}
+			default:
+			}
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				return "Ping timeout"
+			}
+			return "Read error: " + err.Error()
+		}
+		if reason, ok := link.handle(line); !ok {
+			return reason
+		}
+	}
+}
+
+// handle processes a line from the linked server returning false and
+// a reason if the link should be closed.
+func (link *Link) handle(line string) (string, bool) {
+	server := link.server
+	tags, line := SplitTags(line)
+
+	var source Name
+	if strings.HasPrefix(line, ":") {
+		index := strings.Index(line, " ")
+		if index < 0 {
+			return "", true
+		}
+		source = NewName(strings.SplitN(line[1:index], "!", 2)[0])
+		line = line[index+1:]
+	}
+
+	_, code, args := ParseLine(line)
+	switch code {
+	case PING:
+		link.Send(NewStringReply(server, PONG, ":%s", strings.Join(args, " ")))
+		return "", true
+
+	case PONG:
+		return "", true
+
+	case ERROR:
+		return "Remote error: " + strings.Join(args, " "), false
+
+	case SERVER:
+		return link.handleServer(source, args)
+
+	case SQUIT:
+		link.handleSquit(source, args)
+		return "", true
+
+	case KILL:
+		link.handleKill(source, args)
+		return "", true
+	}
+
+	if remote := server.RemoteServer(source); remote != nil {
+		if remote.link != link {
+			log.Warnf("%s: ignoring %s from %s on the wrong link", link, code, source)
+			return "", true
+		}
+		link.handleServerCommand(remote, code, args, line)
+		return "", true
+	}
+
+	client := server.clients.Get(source)
+	if client == nil || client.link != link {
+		log.Debugf("%s: ignoring %s from unknown source %s", link, code, source)
+		return "", true
+	}
+
+	switch code {
+	case NICK:
+		link.handleNick(client, args)
+
+	case ACCOUNT:
+		account := "*"
+		if len(args) > 0 && args[0] != "" {
+			account = args[0]
+		}
+		if account != "*" {
+			client.sasl.Login(account)
+		} else {
+			client.sasl.Reset()
+		}
+		server.Relay(client, NewStringReply(client, ACCOUNT, "%s", account))
+
+	case CHGHOST:
+		if len(args) > 1 {
+			client.ChangeHost(NewName(args[0]), NewName(args[1]))
+		}
+
+	default:
+		if !linkCommands[code] {
+			log.Debugf("%s: ignoring unsupported command %s", link, code)
+			return "", true
+		}
+
+		command, err := ParseCommand(WithTags(line, tags))
+		if err != nil {
+			log.Debugf("%s: error parsing %s: %s", link, line, err)
+			return "", true
+		}
+		command.SetClient(client)
+		if srvCmd, ok := command.(ServerCommand); ok {
+			srvCmd.HandleServer(server)
+		}
+	}
+
+	return "", true
+}
+
+// :<uplink> SERVER <name> <hops> :<description>
+func (link *Link) handleServer(source Name, args []string) (string, bool) {
+	server := link.server
+	if len(args) < 3 {
+		return "", true
+	}
+
+	name := NewName(args[0])
+	hops, _ := strconv.ParseUint(args[1], 10, 32)
+	remote := &RemoteServer{
+		name:        name,
+		description: args[2],
+		hops:        uint(hops) + 1,
+		uplink:      source,
+		link:        link,
+	}
+
+	if err := server.addRemoteServer(remote); err != nil {
+		link.Send(RplError(fmt.Sprintf("%s: %s", name, err)))
+		return fmt.Sprintf("%s: %s", name, err), false
+	}
+
+	server.relayFrom(link, RplServer(remote))
+	return "", true
+}
+
+// :<source> SQUIT <name> :<reason>
+func (link *Link) handleSquit(source Name, args []string) {
+	if len(args) < 1 {
+		return
+	}
+
+	remote := link.server.RemoteServer(NewName(args[0]))
+	if remote == nil || remote.link != link || remote == link.RemoteServer {
+		return
+	}
+
+	link.server.removeRemoteServer(remote)
+}
+
+// :<source> KILL <nick> :<reason>
+func (link *Link) handleKill(source Name, args []string) {
+	server := link.server
+	if len(args) < 1 {
+		return
+	}
+
+	target := server.clients.Get(NewName(args[0]))
+	if target == nil {
+		return
+	}
+
+	var reason string
+	if len(args) > 1 {
+		reason = args[1]
+	}
+
+	if target.link != nil && target.link != link {
+		// pass it on towards the server the target is on
+		target.link.Send(RplLinkKill(source, target, reason))
+		return
+	}
+
+	target.Quit(NewText(fmt.Sprintf("Killed (%s (%s))", source, reason)))
+}
+
+// :<old> NICK <new>
+func (link *Link) handleNick(client *Client, args []string) {
+	server := link.server
+	if len(args) < 1 {
+		return
+	}
+
+	nickname := NewName(args[0])
+	if target := server.clients.Get(nickname); (target != nil && target != client) ||
+		server.services[nickname.ToLower()] != nil {
+		link.Send(RplLinkKill(server.name, client, "Nick collision"))
+		client.Quit("Nick collision")
+		return
+	}
+
+	client.ChangeNickname(nickname)
+}
+
+// handleServerCommand handles commands sent by a remote server
+func (link *Link) handleServerCommand(remote *RemoteServer, code StringCode, args []string, line string) {
+	server := link.server
+
+	switch code {
+	case NICK:
+		link.introduce(remote, args)
+
+	case MODE:
+		if len(args) < 2 {
+			return
+		}
+		command, err := ParseModeCommand(args)
+		if err != nil {
+			return
+		}
+		modes, ok := command.(*ChannelModeCommand)
+		if !ok {
+			return
+		}
+		channel := server.channels.Get(modes.channel)
+		if channel == nil {
+			return
+		}
+
+		applied := make(ChannelModeChanges, 0)
+		for _, change := range modes.changes {
+			if channel.applyMode(link.operator, change) {
+				applied = append(applied, change)
+			}
+		}
+		if len(applied) == 0 {
+			return
+		}
+		channel.Save()
+
+		reply := NewStringReply(remote, MODE, "%s %s", channel, applied)
+		channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
+			member.Reply(reply)
+			return true
+		})
+		server.relayFrom(link, reply)
+
+	case TOPIC:
+		if len(args) < 2 {
+			return
+		}
+		channel := server.channels.Get(NewName(args[0]))
+		topic := NewText(args[1])
+		if channel == nil || channel.topic == topic {
+			return
+		}
+		channel.topic = topic
+		channel.Save()
+
+		reply := RplTopicMsg(remote, channel)
+		channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
+			member.Reply(reply)
+			return true
+		})
+		server.relayFrom(link, reply)
+
+	default:
+		log.Debugf("%s: ignoring %s from server %s", link, code, remote)
+	}
+}
+
+// :<server> NICK <nick> <hops> <username> <hostname> <hostmask> <account> <modes> :<realname>
+func (link *Link) introduce(remote *RemoteServer, args []string) {
+	server := link.server
+	if len(args) < 8 {
+		return
+	}
+
+	nickname := NewName(args[0])
+	if server.clients.Get(nickname) != nil || server.services[nickname.ToLower()] != nil {
+		link.Send(NewStringReply(server, KILL, "%s :Nick collision", nickname))
+		return
+	}
+
+	hops, _ := strconv.ParseUint(args[1], 10, 32)
+	client := NewRemoteClient(server, link, remote, uint(hops)+1)
+	client.nick = nickname
+	client.username = NewName(args[2])
+	client.hostname = NewName(args[3])
+	client.hostmask = NewName(args[4])
+	// the cloak secret is the same on all linked servers
+	client.cloak = server.cloaker.Cloak(client.hostname)
+	if args[5] != "*" {
+		client.sasl.Login(args[5])
+	}
+	for _, mode := range strings.TrimPrefix(args[6], "+") {
+		client.SetFlag(UserMode(mode), true)
+	}
+	client.realname = NewText(args[7])
+
+	server.clients.Add(client)
+	server.relayFrom(link, RplIntroduce(client))
+}
+
+// burst sends everything known about the network to a new link
+func (link *Link) burst() {
+	server := link.server
+
+	remotes := server.RemoteServers()
+	sort.Slice(remotes, func(i, j int) bool {
+		return remotes[i].hops < remotes[j].hops
+	})
+	for _, remote := range remotes {
+		if remote.link != link {
+			link.Send(RplServer(remote))
+		}
+	}
+
+	server.clients.Range(func(_ Name, client *Client) bool {
+		if client.registered && client.link != link {
+			link.Send(RplIntroduce(client))
+		}
+		return true
+	})
+
+	server.channels.Range(func(name Name, channel *Channel) bool {
+		members := make(ChannelModeChanges, 0)
+		channel.members.Range(func(member *Client, modes *ChannelModeSet) bool {
+			if member.link == link {
+				return true
+			}
+			link.Send(RplJoin(member, channel))
+			for _, mode := range []ChannelMode{ChannelOperator, Voice} {
+				if modes.Has(mode) {
+					members = append(members, &ChannelModeChange{
+						mode: mode, op: Add, arg: member.Nick().String(),
+					})
+				}
+			}
+			return true
+		})
+
+		for _, changes := range append(channel.burstModes(), members...) {
+			link.Send(NewStringReply(server, MODE, "%s %s",
+				channel, ChannelModeChanges{changes}))
+		}
+		if channel.topic != "" {
+			link.Send(RplTopicMsg(server, channel))
+		}
+		return true
+	})
+}
+
+// burstModes returns the modes of the channel as changes to send to a
+// linked server
+func (channel *Channel) burstModes() ChannelModeChanges {
+	changes := make(ChannelModeChanges, 0)
+	channel.flags.Range(func(mode ChannelMode) bool {
+		changes = append(changes, &ChannelModeChange{mode: mode, op: Add})
+		return true
+	})
+	if channel.key != "" {
+		changes = append(changes, &ChannelModeChange{
+			mode: Key, op: Add, arg: channel.key.String(),
+		})
+	}
+	if channel.userLimit > 0 {
+		changes = append(changes, &ChannelModeChange{
+			mode: UserLimit, op: Add, arg: strconv.FormatUint(channel.userLimit, 10),
+		})
+	}
+	for _, mode := range []ChannelMode{BanMask, ExceptMask, InviteMask} {
+		for mask := range channel.lists[mode].masks {
+			changes = append(changes, &ChannelModeChange{
+				mode: mode, op: Add, arg: mask.String(),
+			})
+		}
+	}
+	return changes
+}
+
+// NewRemoteClient returns a client connected to another server. Remote
+// clients have no socket; replies to them are dropped and only
+// messages addressed to them are sent on through their link.
+func NewRemoteClient(server *Server, link *Link, remote *RemoteServer, hops uint) *Client {
+	now := time.Now()
+	return &Client{
+		atime:        now,
+		authorized:   true,
+		capabilities: make(CapabilitySet),
+		channels:     NewChannelSet(),
+		ctime:        now,
+		flags:        make(map[UserMode]bool),
+		hops:         hops,
+		link:         link,
+		registered:   true,
+		remote:       remote,
+		sasl:         NewSaslState(),
+		server:       server,
+	}
+}
+
+//
+// server
+//
+
+// RemoteServer returns the remote server named name if it is linked
+func (server *Server) RemoteServer(name Name) *RemoteServer {
+	server.linksMutex.RLock()
+	defer server.linksMutex.RUnlock()
+
+	return server.remotes[name.ToLower()]
+}
+
+// RemoteServers returns all servers linked to the network
+func (server *Server) RemoteServers() []*RemoteServer {
+	server.linksMutex.RLock()
+	defer server.linksMutex.RUnlock()
+
+	remotes := make([]*RemoteServer, 0, len(server.remotes))
+	for _, remote := range server.remotes {
+		remotes = append(remotes, remote)
+	}
+	return remotes
+}
+
+func (server *Server) addRemoteServer(remote *RemoteServer) error {
+	server.linksMutex.Lock()
+	defer server.linksMutex.Unlock()
+
+	key := remote.name.ToLower()
+	if key == server.name.ToLower() || server.remotes[key] != nil {
+		return ErrLinkExists
+	}
+	server.remotes[key] = remote
+	if remote.link.RemoteServer == remote {
+		server.links[key] = remote.link
+	}
+	return nil
+}
+
+// removeRemoteServer removes a remote server, the servers behind it
+// and their clients from the network.
+func (server *Server) removeRemoteServer(remote *RemoteServer) {
+	server.linksMutex.Lock()
+	split := map[Name]bool{remote.name.ToLower(): true}
+	for changed := true; changed; {
+		changed = false
+		for key, other := range server.remotes {
+			if !split[key] && split[other.uplink.ToLower()] {
+				split[key] = true
+				changed = true
+			}
+		}
+	}
+	for key := range split {
+		delete(server.remotes, key)
+		delete(server.links, key)
+	}
+	server.linksMutex.Unlock()
+
+	reason := NewText(fmt.Sprintf("%s %s", remote.uplink, remote.name))
+	clients := make([]*Client, 0)
+	server.clients.Range(func(_ Name, client *Client) bool {
+		if client.remote != nil && split[client.remote.name.ToLower()] {
+			clients = append(clients, client)
+		}
+		return true
+	})
+	for _, client := range clients {
+		client.Quit(reason)
+	}
+
+	server.relayFrom(remote.link, NewStringReply(server, SQUIT, "%s :%s", remote.name, reason))
+}
+
+// LinkCount returns the number of directly linked servers
+func (server *Server) LinkCount() int {
+	server.linksMutex.RLock()
+	defer server.linksMutex.RUnlock()
+
+	return len(server.links)
+}
+
+// LocalClientCount returns the number of clients connected to this server
+func (server *Server) LocalClientCount() int {
+	count := 0
+	server.clients.Range(func(_ Name, client *Client) bool {
+		if client.link == nil {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// Relay sends line, describing a change made by source, to every linked
+// server except the one source is connected through.
+func (server *Server) Relay(source *Client, line string) {
+	server.relayFrom(source.link, line)
+}
+
+func (server *Server) relayFrom(from *Link, line string) {
+	server.linksMutex.RLock()
+	defer server.linksMutex.RUnlock()
+
+	for _, link := range server.links {
+		if link != from {
+			link.Send(line)
+		}
+	}
+}
+
+// linkConfig returns the link block for the named server
+func (server *Server) linkConfig(name Name) (*LinkConfig, bool) {
+	for key, conf := range server.Config().Link {
+		if NewName(key).ToLower() == name.ToLower() {
+			return conf, true
+		}
+	}
+	return nil, false
+}
+
+func (server *Server) sendLinkHandshake(socket *Socket, conf *LinkConfig) {
+	socket.Write(NewStringReply(nil, PASS, ":%s", conf.Password))
+	socket.Write(NewStringReply(nil, SERVER, "%s 0 :%s", server.name, server.description))
+}
+
+// readLinkHandshake reads and checks the PASS and SERVER lines of a
+// linking server.
+func (server *Server) readLinkHandshake(socket *Socket) (Name, string, *LinkConfig, error) {
+	var (
+		password    string
+		name        Name
+		description string
+	)
+
+	for name == "" {
+		line, err := socket.Read()
+		if err != nil {
+			return "", "", nil, err
+		}
+		_, code, args := ParseLine(line)
+		switch {
+		case code == PASS && len(args) > 0:
+			password = args[0]
+		case code == SERVER && len(args) > 2:
+			name, description = NewName(args[0]), args[2]
+		case code == ERROR:
+			return "", "", nil, fmt.Errorf("remote error: %s", strings.Join(args, " "))
+		default:
+			return "", "", nil, ErrLinkHandshake
+		}
+	}
+
+	conf, ok := server.linkConfig(name)
+	if !ok {
+		return "", "", nil, ErrLinkNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(conf.Password)) != 1 {
+		return "", "", nil, ErrLinkPasswd
+	}
+	return name, description, conf, nil
+}
+
+// runLink performs the link handshake on conn and then processes the
+// link until it is closed. expected is the name of the server connected
+// to, or empty for incoming links.
+func (server *Server) runLink(conn net.Conn, expected Name) error {
+	socket := NewSocket(conn)
+	defer conn.Close()
+
+	if expected != "" {
+		conf, ok := server.linkConfig(expected)
+		if !ok {
+			return ErrLinkNotFound
+		}
+		server.sendLinkHandshake(socket, conf)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(LINK_TIMEOUT))
+	name, description, conf, err := server.readLinkHandshake(socket)
+	if err == nil && expected != "" && name.ToLower() != expected.ToLower() {
+		err = ErrLinkUnexpected
+	}
+	if err == nil && conf.TLS && !IsSecure(conn) {
+		err = ErrLinkInsecure
+	}
+	if err != nil {
+		socket.Write(RplError(err.Error()))
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if expected == "" {
+		server.sendLinkHandshake(socket, conf)
+	}
+
+	link := NewLink(server, socket, name, description)
+	if err := server.addRemoteServer(link.RemoteServer); err != nil {
+		socket.Write(RplError(err.Error()))
+		return err
+	}
+
+	log.Infof("%s: linked with %s (%s)", server, name, conn.RemoteAddr())
+	server.Wallopsf("Link with %s established", name)
+	server.relayFrom(link, RplServer(link.RemoteServer))
+
+	go link.writeloop()
+	link.burst()
+
+	reason := link.readloop()
+	link.Close()
+
+	log.Infof("%s: link with %s closed: %s", server, name, reason)
+	server.Wallopsf("Link with %s closed: %s", name, reason)
+	server.removeRemoteServer(link.RemoteServer)
+	return nil
+}
+
+// listenLinks accepts links from other servers on addr, over TLS if
+// tlsconfig is not nil
+func (server *Server) listenLinks(addr string, tlsconfig *TLSConfig) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("error binding to %s: %s", addr, err)
+	}
+
+	if tlsconfig != nil {
+		listener = tls.NewListener(listener, server.tlsConfig(tlsconfig))
+		log.Infof("%s listening for links on %s (TLS)", server, addr)
+	} else {
+		log.Infof("%s listening for links on %s", server, addr)
+	}
+
+	go server.acceptLinks(listener)
+}
+
+func (server *Server) acceptLinks(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Errorf("%s link accept error: %s", server, err)
+			continue
+		}
+		go func() {
+			if err := server.runLink(conn, ""); err != nil {
+				log.Warnf("%s: link from %s failed: %s", server, conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// dialLink connects to the server of a link block. With TLS the
+// certificate is checked against the fingerprint of the block if set,
+// and verified against the system roots otherwise.
+func (server *Server) dialLink(conf *LinkConfig) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: LINK_TIMEOUT}
+	if !conf.TLS {
+		return dialer.Dial("tcp", conf.Address)
+	}
+
+	host, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host}
+	if conf.CertFP != "" {
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(certs [][]byte, _ [][]*x509.Certificate) error {
+			if len(certs) == 0 {
+				return ErrLinkCertFP
+			}
+			sum := sha256.Sum256(certs[0])
+			if hex.EncodeToString(sum[:]) != conf.CertFP {
+				return ErrLinkCertFP
+			}
+			return nil
+		}
+	}
+	return tls.DialWithDialer(dialer, "tcp", conf.Address, config)
+}
+
+// autoConnect keeps a link to the named server connected
+func (server *Server) autoConnect(name Name) {
+	for {
+		conf, ok := server.linkConfig(name)
+		if !ok || !conf.Connect {
+			return
+		}
+
+		if server.RemoteServer(name) == nil {
+			conn, err := server.dialLink(conf)
+			if err != nil {
+				log.Warnf("%s: error connecting to %s: %s", server, name, err)
+			} else if err := server.runLink(conn, name); err != nil {
+				log.Warnf("%s: link to %s failed: %s", server, name, err)
+			}
+		}
+
+		time.Sleep(LINK_RETRY)
+	}
+}
+
+//
+// replies
+//
+
+// RplServer introduces a remote server to a linked server
+func RplServer(remote *RemoteServer) string {
+	return fmt.Sprintf(":%s %s %s %d :%s",
+		remote.uplink, SERVER, remote.name, remote.hops, remote.description)
+}
+
+// RplIntroduce introduces a client to a linked server
+func RplIntroduce(client *Client) string {
+	var source Identifiable = client.server
+	if client.remote != nil {
+		source = client.remote
+	}
+
+	account := client.sasl.Id()
+	if account == "" {
+		account = "*"
+	}
+
+	modes := client.ModeString()
+	if modes == "" {
+		modes = "+"
+	}
+
+	return NewStringReply(source, NICK, "%s %d %s %s %s %s %s :%s",
+		client.nick, client.hops, client.username, client.hostname,
+		client.hostmask, account, modes, client.realname)
+}
+
+func RplLinkKill(source Name, target *Client, reason string) string {
+	return fmt.Sprintf(":%s %s %s :%s", source, KILL, target.Nick(), reason)
+}
@@ -0,0 +1,349 @@
+package irc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLink(t *testing.T) (*Server, *Link) {
+	server := &Server{
+		name:     "a.test",
+		channels: NewChannelNameMap(),
+		cloaker:  NewCloaker("secret", 2, "IP"),
+		clients:  NewClientLookupSet(),
+		whoWas:   NewWhoWasList(10),
+		links:    make(map[Name]*Link),
+		remotes:  make(map[Name]*RemoteServer),
+	}
+	link := NewLink(server, nil, "b.test", "Server B")
+	if err := server.addRemoteServer(link.RemoteServer); err != nil {
+		t.Fatal(err)
+	}
+	return server, link
+}
+
+func TestLinkIntroduce(t *testing.T) {
+	server, link := newTestLink(t)
+
+	link.handle(":b.test SERVER c.test 1 :Server C")
+	remote := server.RemoteServer("C.TEST")
+	if remote == nil {
+		t.Fatal("Expected c.test to be linked")
+	}
+	if remote.hops != 2 || remote.uplink != "b.test" || remote.link != link {
+		t.Errorf("Unexpected remote server %+v", remote)
+	}
+
+	link.handle(":c.test NICK foo 1 user host.test mask.test foo +i :Foo Bar")
+	client := server.clients.Get("foo")
+	if client == nil {
+		t.Fatal("Expected foo to be introduced")
+	}
+	if client.Server() != "c.test" || client.hops != 2 {
+		t.Errorf("Expected foo on c.test 2 hops away, got %s %d", client.Server(), client.hops)
+	}
+	if client.sasl.Id() != "foo" || !client.HasFlag(Invisible) {
+		t.Errorf("Expected foo logged in with +i, got %q %s", client.sasl.Id(), client.ModeString())
+	}
+
+	expected := ":c.test NICK foo 2 user host.test mask.test foo +i :Foo Bar"
+	if line := RplIntroduce(client); !strings.HasPrefix(line, expected) {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+}
+
+func TestLinkNickCollision(t *testing.T) {
+	server, link := newTestLink(t)
+
+	link.handle(":b.test NICK foo 0 user host.test mask.test * + :Foo")
+	link.handle(":b.test NICK FOO 0 user host.test mask.test * + :Foo")
+
+	select {
+	case line := <-link.sends:
+		if !strings.Contains(line, "KILL FOO :Nick collision") {
+			t.Errorf("Expected a KILL for FOO, got %q", line)
+		}
+	default:
+		t.Error("Expected a KILL to be sent")
+	}
+	if server.clients.Count() != 1 {
+		t.Errorf("Expected 1 client, got %d", server.clients.Count())
+	}
+}
+
+func TestLinkSquit(t *testing.T) {
+	server, link := newTestLink(t)
+
+	link.handle(":b.test SERVER c.test 1 :Server C")
+	link.handle(":c.test SERVER d.test 1 :Server D")
+	link.handle(":b.test NICK foo 0 user host.test mask.test * + :Foo")
+	link.handle(":d.test NICK bar 0 user host.test mask.test * + :Bar")
+
+	link.handle(":b.test SQUIT c.test :Gone")
+
+	if server.RemoteServer("c.test") != nil || server.RemoteServer("d.test") != nil {
+		t.Error("Expected c.test and d.test to be split")
+	}
+	if server.RemoteServer("b.test") == nil {
+		t.Error("Expected b.test to still be linked")
+	}
+	if server.clients.Get("bar") != nil {
+		t.Error("Expected bar to have quit")
+	}
+	if server.clients.Get("foo") == nil {
+		t.Error("Expected foo to still be connected")
+	}
+}
+
+func TestLinkMalformed(t *testing.T) {
+	verbs := []StringCode{
+		ACCOUNT, CHGHOST, ERROR, JOIN, KICK, KILL, MODE, NICK, NOTICE,
+		PART, PING, PONG, PRIVMSG, QUIT, SERVER, SQUIT, TAGMSG, TOPIC,
+	}
+	for _, source := range []string{"", ":b.test ", ":foo ", ":foo!user@host ", ":unknown "} {
+		for _, verb := range verbs {
+			for _, args := range []string{"", " ", " :", " #test", " foo", " #test foo"} {
+				server, link := newTestLink(t)
+				server.channelStore = NewMemoryChannelStore()
+				link.handle(":b.test NICK foo 0 user host.test mask.test * + :Foo")
+				link.handle(":foo JOIN #test")
+
+				line := source + verb.String() + args
+				func() {
+					defer func() {
+						if err := recover(); err != nil {
+							t.Errorf("Unexpected panic handling %q: %v", line, err)
+						}
+					}()
+					link.handle(line)
+				}()
+			}
+		}
+	}
+	server, link := newTestLink(t)
+	for _, line := range []string{"", ":", ":b.test", "@", "@tag :b.test"} {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					t.Errorf("Unexpected panic handling %q: %v", line, err)
+				}
+			}()
+			link.handle(line)
+		}()
+	}
+	if server.RemoteServer("b.test") == nil {
+		t.Error("Expected b.test to still be linked")
+	}
+}
+
+func TestLinkSendQueueFull(t *testing.T) {
+	_, link := newTestLink(t)
+
+	for i := 0; i < LINK_QUEUE; i++ {
+		link.Send("PING :a.test")
+	}
+	link.Send("PING :a.test")
+
+	select {
+	case <-link.done:
+	default:
+		t.Fatal("Expected the link to be dropped when its queue is full")
+	}
+	if link.reason != "SendQ exceeded" {
+		t.Errorf("Unexpected reason %q", link.reason)
+	}
+}
+
+// timeoutConn is a connection whose reads time out
+type timeoutConn struct {
+	net.Conn
+}
+
+func (conn *timeoutConn) Read(b []byte) (int, error) {
+	return 0, &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}
+}
+
+func TestLinkPingTimeout(t *testing.T) {
+	server, _ := newTestLink(t)
+	conn, _ := net.Pipe()
+	link := NewLink(server, NewSocket(&timeoutConn{conn}), "c.test", "Server C")
+
+	if reason := link.readloop(); reason != "Ping timeout" {
+		t.Errorf("Expected a ping timeout, got %q", reason)
+	}
+}
+
+// writeTestCert writes a self-signed certificate and its key to dir
+// returning their config and the certificate fingerprint
+func writeTestCert(t *testing.T, dir string) (*TLSConfig, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "a.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &TLSConfig{
+		Cert: filepath.Join(dir, "cert.pem"),
+		Key:  filepath.Join(dir, "key.pem"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err := ioutil.WriteFile(conf.Cert, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(conf.Key, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(cert)
+	return conf, hex.EncodeToString(sum[:])
+}
+
+func newTestLinkServer(name Name, links map[string]*LinkConfig) *Server {
+	server := &Server{
+		config:   &Config{Link: links},
+		name:     name,
+		channels: NewChannelNameMap(),
+		cloaker:  NewCloaker("secret", 2, "IP"),
+		clients:  NewClientLookupSet(),
+		whoWas:   NewWhoWasList(10),
+		links:    make(map[Name]*Link),
+		remotes:  make(map[Name]*RemoteServer),
+	}
+	return server
+}
+
+func TestLinkTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tlsconfig, certfp := writeTestCert(t, dir)
+
+	a := newTestLinkServer("a.test", map[string]*LinkConfig{
+		"b.test": {Password: "secret", TLS: true},
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.acceptLinks(tls.NewListener(listener, a.tlsConfig(tlsconfig)))
+
+	conf := &LinkConfig{
+		Address:  listener.Addr().String(),
+		Password: "secret",
+		TLS:      true,
+		CertFP:   strings.Repeat("00", 32),
+	}
+	b := newTestLinkServer("b.test", map[string]*LinkConfig{"a.test": conf})
+	if _, err := b.dialLink(conf); err == nil {
+		t.Error("Expected a certificate with another fingerprint to be refused")
+	}
+
+	conf.CertFP = certfp
+	conn, err := b.dialLink(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.runLink(conn, "a.test")
+	defer conn.Close()
+
+	for i := 0; a.RemoteServer("b.test") == nil; i++ {
+		if i == 100 {
+			t.Fatal("Expected b.test to link over TLS")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	local, remote := net.Pipe()
+	go func() {
+		socket := NewSocket(remote)
+		socket.Write("PASS :secret")
+		socket.Write("SERVER b.test 0 :Server B")
+		for {
+			if _, err := socket.Read(); err != nil {
+				return
+			}
+		}
+	}()
+	if err := a.runLink(local, ""); err != ErrLinkInsecure {
+		t.Errorf("Expected a link without TLS to be refused, got %v", err)
+	}
+}
+
+func TestLinkHistoryMsgId(t *testing.T) {
+	server, link := newTestLink(t)
+	server.channelStore = NewMemoryChannelStore()
+	server.history = NewMemoryHistoryStore(10)
+	link.handle(":b.test NICK foo 0 user host.test mask.test * + :Foo")
+	link.handle(":foo JOIN #test")
+
+	link.handle("@msgid=abc;time=2020-01-02T03:04:05.678Z;+draft/reply=xyz :foo PRIVMSG #test :hi")
+
+	items, _ := server.history.Get("#test")
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 history item, got %d", len(items))
+	}
+	expected := time.Date(2020, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	if items[0].MsgId != "abc" || !items[0].Time.Equal(expected) {
+		t.Errorf("Expected the msgid and time of the origin, got %s %s", items[0].MsgId, items[0].Time)
+	}
+	if len(items[0].Tags) != 1 || items[0].Tags["+draft/reply"] != "xyz" {
+		t.Errorf("Expected only the client-only tags to be kept, got %v", items[0].Tags)
+	}
+}
+
+func TestLinkTagMsg(t *testing.T) {
+	server, link := newTestLink(t)
+	link.handle(":b.test NICK foo 0 user host.test mask.test * + :Foo")
+
+	client := &Client{
+		flags:    make(map[UserMode]bool),
+		hostname: "host.test",
+		nick:     "bar",
+		server:   server,
+		username: "user",
+	}
+	msg := &TagMsgCommand{target: "foo"}
+	msg.SetClient(client)
+	msg.SetTags(Tags{"+typing": "active"})
+	msg.HandleServer(server)
+
+	select {
+	case line := <-link.sends:
+		if !strings.Contains(line, "+typing=active") || !strings.HasSuffix(line, " TAGMSG foo") {
+			t.Errorf("Unexpected relayed TAGMSG %q", line)
+		}
+	default:
+		t.Error("Expected the TAGMSG to be relayed to the server of foo")
+	}
+}
@@ -18,18 +18,20 @@ var DefObjectives = map[float64]float64{
 }
 
 type Metrics struct {
-	namespace string
-	metrics   map[string]prometheus.Metric
-	gaugevecs map[string]*prometheus.GaugeVec
-	sumvecs   map[string]*prometheus.SummaryVec
+	namespace   string
+	metrics     map[string]prometheus.Metric
+	countervecs map[string]*prometheus.CounterVec
+	gaugevecs   map[string]*prometheus.GaugeVec
+	sumvecs     map[string]*prometheus.SummaryVec
 }
 
 func NewMetrics(namespace string) *Metrics {
 	return &Metrics{
-		namespace: namespace,
-		metrics:   make(map[string]prometheus.Metric),
-		gaugevecs: make(map[string]*prometheus.GaugeVec),
-		sumvecs:   make(map[string]*prometheus.SummaryVec),
+		namespace:   namespace,
+		metrics:     make(map[string]prometheus.Metric),
+		countervecs: make(map[string]*prometheus.CounterVec),
+		gaugevecs:   make(map[string]*prometheus.GaugeVec),
+		sumvecs:     make(map[string]*prometheus.SummaryVec),
 	}
 }
 
@@ -68,6 +70,24 @@ func (m *Metrics) NewCounterFunc(subsystem, name, help string, f func() float64)
 	return counter
 }
 
+func (m *Metrics) NewCounterVec(subsystem, name, help string, labels []string) *prometheus.CounterVec {
+	countervec := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: m.namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)
+
+	key := fmt.Sprintf("%s_%s", subsystem, name)
+	m.countervecs[key] = countervec
+	prometheus.MustRegister(countervec)
+
+	return countervec
+}
+
 func (m *Metrics) NewGauge(subsystem, name, help string) prometheus.Gauge {
 	guage := prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -163,6 +183,11 @@ func (m *Metrics) Counter(subsystem, name string) prometheus.Counter {
 	return m.metrics[key].(prometheus.Counter)
 }
 
+func (m *Metrics) CounterVec(subsystem, name string) *prometheus.CounterVec {
+	key := fmt.Sprintf("%s_%s", subsystem, name)
+	return m.countervecs[key]
+}
+
 func (m *Metrics) Gauge(subsystem, name string) prometheus.Gauge {
 	key := fmt.Sprintf("%s_%s", subsystem, name)
 	return m.metrics[key].(prometheus.Gauge)
@@ -51,13 +51,14 @@ const (
 )
 
 const (
-	Away       UserMode = 'a' // not a real user mode (flag)
-	Invisible  UserMode = 'i'
-	Operator   UserMode = 'o'
-	WallOps    UserMode = 'w'
-	Registered UserMode = 'r' // not a real user mode (flag)
-	SecureConn UserMode = 'z'
-	SecureOnly UserMode = 'Z'
+	Away         UserMode = 'a' // not a real user mode (flag)
+	Invisible    UserMode = 'i'
+	Operator     UserMode = 'o'
+	WallOps      UserMode = 'w'
+	ServerNotice UserMode = 's' // audit log notices, operators only
+	Registered   UserMode = 'r' // not a real user mode (flag)
+	SecureConn   UserMode = 'z'
+	SecureOnly   UserMode = 'Z'
 )
 
 var (
@@ -107,7 +108,7 @@ func (m *ModeCommand) HandleServer(s *Server) {
 		return
 	}
 
-	if client != target && !client.flags[Operator] {
+	if client != target && !client.HasPrivilege(PrivUserModes) {
 		client.ErrUsersDontMatch()
 		return
 	}
@@ -117,28 +118,20 @@ func (m *ModeCommand) HandleServer(s *Server) {
 	for _, change := range m.changes {
 		switch change.mode {
 		case Invisible, WallOps, SecureOnly:
-			switch change.op {
-			case Add:
-				if target.flags[change.mode] {
-					continue
-				}
-				target.flags[change.mode] = true
+			if target.SetFlag(change.mode, change.op == Add) {
 				changes = append(changes, change)
+			}
 
-			case Remove:
-				if !target.flags[change.mode] {
-					continue
-				}
-				delete(target.flags, change.mode)
+		case ServerNotice:
+			if change.op == Add && !target.HasFlag(Operator) {
+				continue
+			}
+			if target.SetFlag(change.mode, change.op == Add) {
 				changes = append(changes, change)
 			}
 
 		case Operator:
-			if change.op == Remove {
-				if !target.flags[change.mode] {
-					continue
-				}
-				delete(target.flags, change.mode)
+			if change.op == Remove && target.SetFlag(change.mode, false) {
 				changes = append(changes, change)
 			}
 		}
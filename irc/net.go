@@ -1,6 +1,9 @@
 package irc
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"net"
 	"strings"
 )
@@ -28,6 +31,52 @@ func LookupHostname(addr Name) Name {
 	return Name(hostname)
 }
 
+// IsSecure returns true if conn is encrypted with TLS or comes from a
+// proxy the client connected to with TLS.
+func IsSecure(conn net.Conn) bool {
+	switch conn := conn.(type) {
+	case *tls.Conn:
+		return true
+	case *ProxyConn:
+		return conn.Secure()
+	case *WSConn:
+		return conn.secure || IsSecure(conn.ws.UnderlyingConn())
+	}
+	return false
+}
+
+// IsCertFP returns true if certfp is a hex encoded SHA-256 fingerprint
+func IsCertFP(certfp string) bool {
+	if len(certfp) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(certfp)
+	return err == nil
+}
+
+// CertFP returns the hex encoded SHA-256 fingerprint of the client
+// certificate presented on conn, or an empty string if there is none.
+func CertFP(conn net.Conn) string {
+	if wsconn, ok := conn.(*WSConn); ok {
+		return wsconn.certfp
+	}
+	tlsconn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	if err := tlsconn.Handshake(); err != nil {
+		return ""
+	}
+
+	certs := tlsconn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(certs[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
 var allowedHostnameChars = "abcdefghijklmnopqrstuvwxyz1234567890-."
 
 func IsHostname(name string) bool {
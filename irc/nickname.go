@@ -1,5 +1,86 @@
 package irc
 
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	NickEnforceReject = "reject" // refuse the nickname
+	NickEnforceRename = "rename" // rename to a guest nickname after a timeout
+	NickEnforceKill   = "kill"   // disconnect the client after a timeout
+
+	DEFAULT_NICK_TIMEOUT = 30 * time.Second
+
+	GUEST_NICKS = 10000 // number of Guest#### nicknames
+)
+
+// IsNickReserved returns true if nickname belongs to a registered account
+// that the client is not logged into.
+func (server *Server) IsNickReserved(client *Client, nickname Name) bool {
+	if server.Config().NickReservation.Enforce == "" || client.link != nil {
+		return false
+	}
+
+	account := CanonicalAccountName(nickname.String())
+	if _, ok := server.accounts.Get(account); !ok {
+		return false
+	}
+	return client.sasl.Id() != account
+}
+
+// GuestNickname returns an unused nickname of the form Guest####, or an
+// empty name if they are all in use.
+func (server *Server) GuestNickname() Name {
+	start := rand.Intn(GUEST_NICKS)
+	for i := 0; i < GUEST_NICKS; i++ {
+		nickname := NewName(fmt.Sprintf("Guest%04d", (start+i)%GUEST_NICKS))
+		if server.clients.Get(nickname) == nil {
+			return nickname
+		}
+	}
+	return ""
+}
+
+// EnforceNick warns the client that its nickname is reserved and
+// renames or kills it unless it logs in before the timeout.
+func (client *Client) EnforceNick() {
+	server := client.server
+	timeout := server.Config().NickReservation.Timeout
+
+	if nickserv := server.services["nickserv"]; nickserv != nil {
+		nickserv.Notice(client,
+			"This nickname is registered. Please identify within %s or it will be taken from you.",
+			timeout)
+	}
+
+	if client.nickTimer == nil {
+		client.nickTimer = time.AfterFunc(timeout, client.nickTimeout)
+	} else {
+		client.nickTimer.Reset(timeout)
+	}
+}
+
+func (client *Client) nickTimeout() {
+	server := client.server
+	if client.hasQuit || !server.IsNickReserved(client, client.nick) {
+		return
+	}
+
+	switch server.Config().NickReservation.Enforce {
+	case NickEnforceRename:
+		guest := server.GuestNickname()
+		if guest == "" {
+			client.Quit("Nickname enforcement")
+			return
+		}
+		client.ChangeNickname(guest)
+	case NickEnforceKill:
+		client.Quit("Nickname enforcement")
+	}
+}
+
 type NickCommand struct {
 	BaseCommand
 	nickname Name
@@ -18,7 +99,7 @@ func (m *NickCommand) HandleRegServer(s *Server) {
 		return
 	}
 
-	if s.clients.Get(m.nickname) != nil {
+	if s.clients.Get(m.nickname) != nil || s.services[m.nickname.ToLower()] != nil {
 		client.ErrNickNameInUse(m.nickname)
 		return
 	}
@@ -50,12 +131,23 @@ func (msg *NickCommand) HandleServer(server *Server) {
 	}
 
 	target := server.clients.Get(msg.nickname)
-	if (target != nil) && (target != client) {
+	if ((target != nil) && (target != client)) ||
+		(server.services[msg.nickname.ToLower()] != nil) {
 		client.ErrNickNameInUse(msg.nickname)
 		return
 	}
 
+	reserved := server.IsNickReserved(client, msg.nickname)
+	if reserved && server.Config().NickReservation.Enforce == NickEnforceReject {
+		client.ErrNickReserved(msg.nickname)
+		return
+	}
+
 	client.ChangeNickname(msg.nickname)
+
+	if reserved {
+		client.EnforceNick()
+	}
 }
 
 type OperNickCommand struct {
@@ -67,7 +159,7 @@ type OperNickCommand struct {
 func (msg *OperNickCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
-	if !client.flags[Operator] {
+	if !client.HasPrivilege(PrivOperNick) {
 		client.ErrNoPrivileges()
 		return
 	}
@@ -82,15 +174,16 @@ func (msg *OperNickCommand) HandleServer(server *Server) {
 	}
 
 	target := server.clients.Get(msg.target)
-	if target == nil {
+	if target == nil || target.link != nil {
 		client.ErrNoSuchNick(msg.target)
 		return
 	}
 
-	if server.clients.Get(msg.nick) != nil {
+	if server.clients.Get(msg.nick) != nil || server.services[msg.nick.ToLower()] != nil {
 		client.ErrNickNameInUse(msg.nick)
 		return
 	}
 
+	server.Audit(NewAuditEntry(AuditOperNick, client, target.UserHost(false).String(), msg.nick.String()))
 	target.ChangeNickname(msg.nick)
 }
@@ -0,0 +1,144 @@
+package irc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestNickServer(enforce string) *Server {
+	metrics := NewMetrics("test")
+	metrics.gaugevecs["server_clients"] = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "clients"}, []string{"type"},
+	)
+
+	server := &Server{
+		config:      &Config{},
+		metrics:     metrics,
+		name:        "test.server",
+		clients:     NewClientLookupSet(),
+		connections: &Counter{},
+		connLimits:  NewConnLimiter(&ConnLimitConfig{}),
+		whoWas:      NewWhoWasList(10),
+		accounts: NewMemoryPasswordStore(
+			map[string][]byte{"alice": []byte("hash")}, PasswordStoreOpts{},
+		),
+	}
+	server.config.NickReservation.Enforce = enforce
+	server.config.NickReservation.Timeout = time.Hour
+	return server
+}
+
+func newTestNickClient(server *Server, nick Name) *Client {
+	conn, _ := net.Pipe()
+	client := &Client{
+		channels:   NewChannelSet(),
+		flags:      make(map[UserMode]bool),
+		hostname:   "host.test",
+		registered: true,
+		replies:    make(chan string, 10),
+		sasl:       NewSaslState(),
+		server:     server,
+		socket:     NewSocket(conn),
+		username:   "user",
+	}
+	client.SetNickname(nick)
+	return client
+}
+
+func changeNick(client *Client, nick Name) {
+	msg := &NickCommand{nickname: nick}
+	msg.SetClient(client)
+	msg.HandleServer(client.server)
+}
+
+func TestNickEnforceReject(t *testing.T) {
+	server := newTestNickServer(NickEnforceReject)
+	client := newTestNickClient(server, "bob")
+
+	changeNick(client, "Alice")
+	if client.nick != "bob" {
+		t.Fatalf("Expected the reserved nickname to be refused, got %s", client.nick)
+	}
+	if reply := <-client.replies; !strings.Contains(reply, " 433 bob Alice :Nickname is reserved") {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	client.sasl.Login("alice")
+	changeNick(client, "Alice")
+	if client.nick != "Alice" {
+		t.Errorf("Expected a client logged into the account to take the nickname, got %s", client.nick)
+	}
+}
+
+func TestNickEnforceRename(t *testing.T) {
+	server := newTestNickServer(NickEnforceRename)
+	client := newTestNickClient(server, "bob")
+
+	changeNick(client, "alice")
+	if client.nick != "alice" || client.nickTimer == nil {
+		t.Fatalf("Expected the client to be given time to log in as alice, got %s", client.nick)
+	}
+	client.nickTimer.Stop()
+
+	client.nickTimeout()
+	if !strings.HasPrefix(client.nick.String(), "Guest") {
+		t.Errorf("Expected the client to be renamed to a guest nickname, got %s", client.nick)
+	}
+	if server.clients.Get("alice") != nil {
+		t.Error("Expected alice to be free after the rename")
+	}
+}
+
+func TestNickEnforceRenameLoggedIn(t *testing.T) {
+	server := newTestNickServer(NickEnforceRename)
+	client := newTestNickClient(server, "bob")
+
+	changeNick(client, "alice")
+	client.nickTimer.Stop()
+	client.sasl.Login("alice")
+
+	client.nickTimeout()
+	if client.nick != "alice" || client.hasQuit {
+		t.Errorf("Expected a client that logged in to keep its nickname, got %s", client.nick)
+	}
+}
+
+func TestNickEnforceKill(t *testing.T) {
+	server := newTestNickServer(NickEnforceKill)
+	client := newTestNickClient(server, "bob")
+
+	changeNick(client, "alice")
+	client.nickTimer.Stop()
+
+	client.nickTimeout()
+	if !client.hasQuit || server.clients.Get("alice") != nil {
+		t.Error("Expected the client to be disconnected")
+	}
+}
+
+func TestGuestNickname(t *testing.T) {
+	server := newTestNickServer(NickEnforceRename)
+	for i := 0; i < GUEST_NICKS-1; i++ {
+		server.clients.Add(&Client{nick: NewName(fmt.Sprintf("Guest%04d", i))})
+	}
+
+	if nick := server.GuestNickname(); nick != NewName(fmt.Sprintf("Guest%04d", GUEST_NICKS-1)) {
+		t.Errorf("Expected the last free guest nickname, got %q", nick)
+	}
+
+	server.clients.Add(&Client{nick: NewName(fmt.Sprintf("Guest%04d", GUEST_NICKS-1))})
+	if nick := server.GuestNickname(); nick != "" {
+		t.Errorf("Expected no guest nickname when all are taken, got %q", nick)
+	}
+
+	client := newTestNickClient(server, "alice")
+	client.nickTimeout()
+	if !client.hasQuit {
+		t.Error("Expected the client to be disconnected when no guest nickname is free")
+	}
+}
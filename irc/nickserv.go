@@ -0,0 +1,240 @@
+package irc
+
+import (
+	"strings"
+	"time"
+)
+
+func NewNickServ(server *Server) *Service {
+	service := NewService(server, "NickServ")
+
+	service.AddCommand(
+		"REGISTER", "<password> [<email>]",
+		"Registers an account named after your current nickname",
+		nickservRegister,
+	)
+	service.AddCommand(
+		"IDENTIFY", "[<account>] <password>",
+		"Logs you into an account",
+		nickservIdentify,
+	)
+	service.AddCommand(
+		"PASSWD", "<current password> <new password>",
+		"Changes the password of your account",
+		nickservPasswd,
+	)
+	service.AddCommand(
+		"DROP", "<password>",
+		"Deletes your account",
+		nickservDrop,
+	)
+	service.AddCommand(
+		"CERT", "ADD [<fingerprint>] | DEL <fingerprint> | LIST",
+		"Manages the TLS client certificates that can log into your account with SASL EXTERNAL",
+		nickservCert,
+	)
+	service.AddCommand(
+		"INFO", "[<account>]",
+		"Shows information about an account",
+		nickservInfo,
+	)
+
+	return service
+}
+
+func nickservRegister(service *Service, client *Client, args []string) {
+	if len(args) < 1 {
+		service.Usage(client, "REGISTER")
+		return
+	}
+
+	if client.sasl.Id() != "" {
+		service.Notice(client, "You are already logged in as %s", client.sasl.Id())
+		return
+	}
+
+	var email string
+	if len(args) > 1 {
+		email = args[1]
+	}
+
+	account := client.Nick().String()
+	if err := service.server.RegisterAccount(account, args[0], email); err != nil {
+		service.Notice(client, "Error registering %s: %s", account, err)
+		return
+	}
+
+	service.Notice(client, "Account %s registered", account)
+	client.Login(account)
+}
+
+func nickservIdentify(service *Service, client *Client, args []string) {
+	var account, password string
+
+	switch len(args) {
+	case 1:
+		account, password = client.Nick().String(), args[0]
+	case 2:
+		account, password = args[0], args[1]
+	default:
+		service.Usage(client, "IDENTIFY")
+		return
+	}
+
+	if client.sasl.Id() != "" {
+		service.Notice(client, "You are already logged in as %s", client.sasl.Id())
+		return
+	}
+
+	if err := service.server.VerifyAccount(account, password); err != nil {
+		service.Notice(client, "Invalid account or password")
+		return
+	}
+
+	service.Notice(client, "You are now logged in as %s", account)
+	client.Login(account)
+}
+
+func nickservPasswd(service *Service, client *Client, args []string) {
+	if len(args) < 2 {
+		service.Usage(client, "PASSWD")
+		return
+	}
+
+	account := client.sasl.Id()
+	if account == "" {
+		service.Notice(client, "You are not logged in")
+		return
+	}
+
+	if err := service.server.accounts.Verify(account, args[0]); err != nil {
+		service.Notice(client, "Invalid password")
+		return
+	}
+
+	if err := service.server.ChangePassword(account, args[1]); err != nil {
+		service.Notice(client, "Error changing password: %s", err)
+		return
+	}
+
+	service.Notice(client, "Password changed")
+}
+
+func nickservDrop(service *Service, client *Client, args []string) {
+	if len(args) < 1 {
+		service.Usage(client, "DROP")
+		return
+	}
+
+	account := client.sasl.Id()
+	if account == "" {
+		service.Notice(client, "You are not logged in")
+		return
+	}
+
+	if err := service.server.accounts.Verify(account, args[0]); err != nil {
+		service.Notice(client, "Invalid password")
+		return
+	}
+
+	if err := service.server.DropAccount(account); err != nil {
+		service.Notice(client, "Error dropping %s: %s", account, err)
+		return
+	}
+
+	service.Notice(client, "Account %s dropped", account)
+}
+
+func nickservInfo(service *Service, client *Client, args []string) {
+	account := client.sasl.Id()
+	if len(args) > 0 {
+		account = args[0]
+	}
+	if account == "" {
+		service.Usage(client, "INFO")
+		return
+	}
+
+	info, ok := service.server.accounts.Info(account)
+	if !ok {
+		service.Notice(client, "%s is not registered", account)
+		return
+	}
+
+	service.Notice(client, "Account: %s", info.Name)
+	if !info.Created.IsZero() {
+		service.Notice(client, "Registered: %s", info.Created.Format(time.RFC1123))
+	}
+	if !info.LastLogin.IsZero() {
+		service.Notice(client, "Last login: %s", info.LastLogin.Format(time.RFC1123))
+	}
+	isOwner := client.sasl.Id() == CanonicalAccountName(info.Name)
+	if info.Email != "" && (isOwner || client.HasPrivilege(PrivSeeHosts)) {
+		service.Notice(client, "Email: %s", info.Email)
+	}
+}
+
+func nickservCert(service *Service, client *Client, args []string) {
+	if len(args) < 1 {
+		service.Usage(client, "CERT")
+		return
+	}
+
+	account := client.sasl.Id()
+	if account == "" {
+		service.Notice(client, "You are not logged in")
+		return
+	}
+
+	accounts := service.server.accounts
+	switch strings.ToUpper(args[0]) {
+	case "ADD":
+		certfp := strings.ToLower(client.certfp)
+		if certfp == "" {
+			service.Notice(client, "You are not using a client certificate")
+			return
+		}
+		if len(args) > 1 && !IsCertFP(strings.ToLower(args[1])) {
+			service.Notice(client, "Invalid certificate fingerprint %s", args[1])
+			return
+		}
+		if len(args) > 1 && strings.ToLower(args[1]) != certfp {
+			service.Notice(client, "You can only add the fingerprint of the certificate you are connected with")
+			return
+		}
+		if err := accounts.AddCertFP(account, certfp); err != nil {
+			service.Notice(client, "Error adding %s: %s", certfp, err)
+			return
+		}
+		service.Notice(client, "Added certificate fingerprint %s", certfp)
+
+	case "DEL":
+		if len(args) < 2 {
+			service.Usage(client, "CERT")
+			return
+		}
+		certfp := strings.ToLower(args[1])
+		if !IsCertFP(certfp) {
+			service.Notice(client, "Invalid certificate fingerprint %s", args[1])
+			return
+		}
+		if err := accounts.DelCertFP(account, certfp); err != nil {
+			service.Notice(client, "Error removing %s: %s", certfp, err)
+			return
+		}
+		service.Notice(client, "Removed certificate fingerprint %s", certfp)
+
+	case "LIST":
+		info, _ := accounts.Info(account)
+		if len(info.CertFPs) == 0 {
+			service.Notice(client, "No certificate fingerprints")
+			return
+		}
+		for _, certfp := range info.CertFPs {
+			service.Notice(client, "%s", certfp)
+		}
+
+	default:
+		service.Usage(client, "CERT")
+	}
+}
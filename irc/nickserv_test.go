@@ -0,0 +1,82 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNickServCertAdd(t *testing.T) {
+	server := &Server{
+		name: "test.server",
+		accounts: NewMemoryPasswordStore(
+			map[string][]byte{"alice": []byte("hash")}, PasswordStoreOpts{},
+		),
+	}
+	nickserv := NewNickServ(server)
+
+	certfp := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+	client := &Client{
+		certfp:  certfp,
+		nick:    "alice",
+		replies: make(chan string, 10),
+		sasl:    NewSaslState(),
+		server:  server,
+	}
+	client.sasl.Login("alice")
+
+	for _, fp := range []string{"abcd", other} {
+		nickserv.Dispatch(client, []string{"CERT", "ADD", fp})
+		if _, ok := server.accounts.CertFPAccount(fp); ok {
+			t.Errorf("Expected %s not to be added", fp)
+		}
+		<-client.replies
+	}
+
+	nickserv.Dispatch(client, []string{"CERT", "ADD", strings.ToUpper(certfp)})
+	if account, ok := server.accounts.CertFPAccount(certfp); !ok || account != "alice" {
+		t.Error("Expected the fingerprint of the client certificate to be added")
+	}
+}
+
+func TestNickServDrop(t *testing.T) {
+	server := newTestNickServer("")
+	server.channels = NewChannelNameMap()
+	server.channelStore = NewMemoryChannelStore()
+	server.vhosts = NewMemoryVHostStore()
+	nickserv := NewNickServ(server)
+
+	if err := server.RegisterAccount("bob", "password", ""); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestNickClient(server, "bob")
+	client.Login("bob")
+
+	channel := NewChannel(server, "#bob", false)
+	if err := channel.Register("bob"); err != nil {
+		t.Fatal(err)
+	}
+	other := NewChannel(server, "#alice", false)
+	if err := other.Register("alice"); err != nil {
+		t.Fatal(err)
+	}
+	server.vhosts.Set("bob", "bob.example.org")
+
+	nickserv.Dispatch(client, []string{"DROP", "password"})
+	if client.sasl.Id() != "" {
+		t.Fatal("Expected the client to be logged out of the dropped account")
+	}
+
+	if err := server.RegisterAccount("BOB", "other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.channelStore.Get("#bob"); ok || channel.IsRegistered() {
+		t.Error("Expected the channels of the dropped account to be dropped")
+	}
+	if _, ok := server.channelStore.Get("#alice"); !ok || !other.IsRegistered() {
+		t.Error("Expected the channels of other accounts to be kept")
+	}
+	if vhost := server.VHost("bob"); vhost != "" {
+		t.Errorf("Expected the vhost of the dropped account to be deleted, got %s", vhost)
+	}
+}
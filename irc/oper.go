@@ -0,0 +1,230 @@
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OperPrivilege is something an operator class allows its operators to do
+type OperPrivilege string
+
+const (
+	PrivChanOverride OperPrivilege = "chan-override" // override channel modes and founders
+	PrivGlobalNotice OperPrivilege = "global-notice" // NOTICE * to all clients
+	PrivKill         OperPrivilege = "kill"          // KILL clients on any server
+	PrivKline        OperPrivilege = "kline"         // KLINE, DLINE and their STATS
+	PrivLocalKill    OperPrivilege = "local-kill"    // KILL clients on this server
+	PrivOperNick     OperPrivilege = "onick"         // ONICK
+	PrivRehash       OperPrivilege = "rehash"        // REHASH
+	PrivSeeHosts     OperPrivilege = "see-hosts"     // see real hosts and private details of clients
+	PrivSeeSecret    OperPrivilege = "see-secret"    // see secret and private channels
+	PrivUserModes    OperPrivilege = "user-modes"    // change the modes of other clients
+	PrivVHost        OperPrivilege = "vhost"         // CHGHOST and VHOST
+	PrivWallops      OperPrivilege = "wallops"       // WALLOPS
+)
+
+var (
+	OperPrivileges = []OperPrivilege{
+		PrivChanOverride, PrivGlobalNotice, PrivKill, PrivKline,
+		PrivLocalKill, PrivOperNick, PrivRehash, PrivSeeHosts,
+		PrivSeeSecret, PrivUserModes, PrivVHost, PrivWallops,
+	}
+)
+
+// IsOperPrivilege returns true if name is a known privilege
+func IsOperPrivilege(name string) bool {
+	for _, privilege := range OperPrivileges {
+		if string(privilege) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// OperClass is a named set of privileges of operators
+type OperClass struct {
+	Name       string
+	Privileges map[OperPrivilege]bool
+}
+
+// NewOperClass returns a class with the privileges, or all privileges if
+// none are given
+func NewOperClass(name string, privileges []OperPrivilege) *OperClass {
+	if len(privileges) == 0 {
+		privileges = OperPrivileges
+	}
+	class := &OperClass{
+		Name:       name,
+		Privileges: make(map[OperPrivilege]bool),
+	}
+	for _, privilege := range privileges {
+		class.Privileges[privilege] = true
+	}
+	return class
+}
+
+// Oper is an operator block of the config: how a client authenticates as
+// the operator and the class it then has
+type Oper struct {
+	Name     Name
+	Class    *OperClass
+	Password []byte       // bcrypt hash, no password if nil
+	Account  string       // canonical account name
+	CertFP   string       // lowercase fingerprint
+	Hosts    *UserMaskSet // nick!user@host masks, any host if nil
+	AutoOper bool
+}
+
+// Matches returns true if the client meets the requirements of the
+// operator other than the password
+func (oper *Oper) Matches(client *Client) bool {
+	if oper.Account != "" && CanonicalAccountName(client.sasl.Id()) != oper.Account {
+		return false
+	}
+	if oper.CertFP != "" && strings.ToLower(client.certfp) != oper.CertFP {
+		return false
+	}
+	if oper.Hosts != nil {
+		userhost := NewName(strings.ToLower(client.UserHost(false).String()))
+		ipmask := NewName(strings.ToLower(fmt.Sprintf("%s!%s@%s", client.Nick(), client.username, client.ip)))
+		if !oper.Hosts.Match(userhost) && (client.ip == nil || !oper.Hosts.Match(ipmask)) {
+			return false
+		}
+	}
+	return true
+}
+
+// BecomeOper makes the client an operator with the class of oper
+func (client *Client) BecomeOper(oper *Oper) {
+	log.Infof("%s: opered as %s", client, oper.Name)
+	client.flagsMutex.Lock()
+	client.oper = oper.Name
+	client.operClass = oper.Class
+	client.flags[Operator] = true
+	client.flags[WallOps] = true
+	client.flagsMutex.Unlock()
+	client.RplYoureOper()
+	client.Reply(
+		RplModeChanges(
+			client, client,
+			ModeChanges{
+				&ModeChange{mode: Operator, op: Add},
+				&ModeChange{mode: WallOps, op: Add},
+			},
+		),
+	)
+}
+
+// deoper removes the operator modes of the client
+func (client *Client) deoper() {
+	changes := make(ModeChanges, 0, 3)
+	client.flagsMutex.Lock()
+	for _, mode := range []UserMode{Operator, WallOps, ServerNotice} {
+		if client.flags[mode] {
+			delete(client.flags, mode)
+			changes = append(changes, &ModeChange{mode: mode, op: Remove})
+		}
+	}
+	client.oper = ""
+	client.operClass = nil
+	client.flagsMutex.Unlock()
+
+	if len(changes) > 0 {
+		client.Reply(RplModeChanges(client, client, changes))
+	}
+}
+
+// reloadOpers gives local operators the class of their operator block
+// after a rehash, and de-opers those whose block was removed
+func (server *Server) reloadOpers() {
+	server.clients.Range(func(_ Name, client *Client) bool {
+		if client.link != nil || !client.HasFlag(Operator) {
+			return true
+		}
+
+		client.flagsMutex.Lock()
+		name := client.oper
+		oper := server.operators[name]
+		if oper != nil {
+			client.operClass = oper.Class
+		}
+		client.flagsMutex.Unlock()
+		if oper != nil {
+			return true
+		}
+
+		log.Infof("%s: de-opered, operator %s removed", client, name)
+		client.Reply(RplNotice(server, client, NewText("Your operator block was removed")))
+		client.deoper()
+		return true
+	})
+}
+
+// IsOperAccount returns true if an operator block requires account
+func (server *Server) IsOperAccount(account string) bool {
+	account = CanonicalAccountName(account)
+	for _, oper := range server.operators {
+		if oper.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOperAccounts warns of operator blocks requiring accounts that do
+// not exist, which no client can oper as
+func (server *Server) checkOperAccounts() {
+	for _, oper := range server.operators {
+		if oper.Account == "" {
+			continue
+		}
+		if _, ok := server.accounts.Get(oper.Account); !ok {
+			log.Warnf("operator %s requires account %s which does not exist", oper.Name, oper.Account)
+		}
+	}
+}
+
+// AutoOper opers a registered client matching an operator with autooper
+// set, which requires an account or a client certificate
+func (server *Server) AutoOper(client *Client) {
+	if client.HasFlag(Operator) || !client.registered {
+		return
+	}
+
+	names := make([]string, 0, len(server.operators))
+	for name := range server.operators {
+		names = append(names, name.String())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		oper := server.operators[NewName(name)]
+		if oper.AutoOper && (oper.Account != "" || oper.CertFP != "") && oper.Matches(client) {
+			client.BecomeOper(oper)
+			server.Audit(NewAuditEntry(AuditOper, client, oper.Name.String(), "autooper"))
+			return
+		}
+	}
+}
+
+// HasPrivilege returns true if the client is an operator whose class
+// allows privilege
+func (client *Client) HasPrivilege(privilege OperPrivilege) bool {
+	client.flagsMutex.RLock()
+	defer client.flagsMutex.RUnlock()
+
+	return client.flags[Operator] && client.operClass != nil &&
+		client.operClass.Privileges[privilege]
+}
+
+// OperClass returns the class of the operator, or nil if the client
+// is not one
+func (client *Client) OperClass() *OperClass {
+	client.flagsMutex.RLock()
+	defer client.flagsMutex.RUnlock()
+
+	return client.operClass
+}
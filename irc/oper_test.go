@@ -0,0 +1,190 @@
+package irc
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestOperClasses(t *testing.T) {
+	config := &Config{}
+	config.OperClass = map[string]*OperClassConfig{
+		"helper": {Privileges: []string{"see-secret", "local-kill"}},
+	}
+	config.Operator = map[string]*OperatorConfig{
+		"admin":  {},
+		"helper": {Class: "helper"},
+	}
+	operators := config.Operators()
+
+	admin := &Client{flags: map[UserMode]bool{Operator: true}, operClass: operators["admin"].Class}
+	for _, privilege := range OperPrivileges {
+		if !admin.HasPrivilege(privilege) {
+			t.Errorf("Expected an operator without a class to have %s", privilege)
+		}
+	}
+
+	helper := &Client{flags: map[UserMode]bool{Operator: true}, operClass: operators["helper"].Class}
+	if !helper.HasPrivilege(PrivSeeSecret) || !helper.HasPrivilege(PrivLocalKill) {
+		t.Error("Expected the helper to have the privileges of its class")
+	}
+	if helper.HasPrivilege(PrivKill) || helper.HasPrivilege(PrivRehash) {
+		t.Error("Expected the helper not to have privileges outside its class")
+	}
+
+	helper.SetFlag(Operator, false)
+	if helper.HasPrivilege(PrivSeeSecret) {
+		t.Error("Expected a client no longer +o to have no privileges")
+	}
+
+	channel := &Channel{flags: NewChannelModeSet(), members: NewMemberSet()}
+	channel.flags.Set(Secret)
+	if !CanSeeChannel(admin, channel) || CanSeeChannel(helper, channel) {
+		t.Error("Expected only operators with see-secret to see secret channels")
+	}
+}
+
+func TestOperMatches(t *testing.T) {
+	config := &Config{}
+	config.Operator = map[string]*OperatorConfig{
+		"account": {Account: "Alice"},
+		"certfp":  {CertFP: "ABCDEF"},
+		"hosts":   {Hosts: []string{"alice@*.example.com", "*@192.0.2.*"}},
+	}
+	operators := config.Operators()
+
+	client := &Client{
+		hostname: "Host.Example.com",
+		ip:       net.ParseIP("198.51.100.1"),
+		nick:     "alice",
+		sasl:     NewSaslState(),
+		username: "alice",
+	}
+	for name, expected := range map[Name]bool{"account": false, "certfp": false, "hosts": true} {
+		if operators[name].Matches(client) != expected {
+			t.Errorf("Expected %s to match %v", name, expected)
+		}
+	}
+
+	client.sasl.Login("alice")
+	client.certfp = "abcdef"
+	client.username = "bob"
+	for name, expected := range map[Name]bool{"account": true, "certfp": true, "hosts": false} {
+		if operators[name].Matches(client) != expected {
+			t.Errorf("Expected %s to match %v", name, expected)
+		}
+	}
+
+	client.ip = net.ParseIP("192.0.2.1")
+	if !operators["hosts"].Matches(client) {
+		t.Error("Expected hosts to match the IP address of the client")
+	}
+}
+
+func TestAutoOper(t *testing.T) {
+	config := &Config{}
+	config.OperClass = map[string]*OperClassConfig{
+		"helper": {Privileges: []string{"local-kill"}},
+	}
+	config.Operator = map[string]*OperatorConfig{
+		"alice":    {Account: "alice", Class: "helper", AutoOper: true},
+		"password": {PassConfig: PassConfig{Password: "JDJhJDA0JG1V"}, AutoOper: true},
+	}
+
+	server := &Server{name: "test.server", operators: config.Operators()}
+	client := &Client{
+		flags:      make(map[UserMode]bool),
+		nick:       "alice",
+		registered: true,
+		sasl:       NewSaslState(),
+		server:     server,
+	}
+
+	server.AutoOper(client)
+	if client.HasFlag(Operator) {
+		t.Fatal("Expected a client not logged in not to be opered")
+	}
+
+	client.sasl.Login("alice")
+	server.AutoOper(client)
+	if !client.HasFlag(Operator) || client.operClass.Name != "helper" {
+		t.Fatal("Expected the client to be opered with the helper class")
+	}
+}
+
+func TestReloadOpers(t *testing.T) {
+	config := &Config{}
+	config.OperClass = map[string]*OperClassConfig{
+		"helper": {Privileges: []string{"local-kill"}},
+	}
+	config.Operator = map[string]*OperatorConfig{
+		"admin":  {},
+		"helper": {Class: "helper"},
+	}
+
+	server := &Server{name: "test.server", clients: NewClientLookupSet(), operators: config.Operators()}
+	newOper := func(nick Name) *Client {
+		client := &Client{
+			flags:    make(map[UserMode]bool),
+			nick:     nick,
+			replies:  make(chan string, 10),
+			server:   server,
+			username: "user",
+		}
+		server.clients.Add(client)
+		client.BecomeOper(server.operators[nick])
+		client.SetFlag(ServerNotice, true)
+		return client
+	}
+	admin := newOper("admin")
+	helper := newOper("helper")
+
+	config.OperClass["helper"].Privileges = []string{"kill"}
+	delete(config.Operator, "admin")
+	server.operators = config.Operators()
+	for len(admin.replies) > 0 {
+		<-admin.replies
+	}
+
+	// Rehash runs in the goroutine of the operator sending REHASH
+	done := make(chan bool)
+	go func() {
+		server.reloadOpers()
+		close(done)
+	}()
+	helper.HasPrivilege(PrivKill)
+	<-done
+
+	if !helper.HasPrivilege(PrivKill) || helper.HasPrivilege(PrivLocalKill) {
+		t.Error("Expected the helper to have the privileges of its reloaded class")
+	}
+	if admin.HasFlag(Operator) || admin.HasFlag(WallOps) || admin.HasFlag(ServerNotice) ||
+		admin.HasPrivilege(PrivKill) {
+		t.Error("Expected the operator whose block was removed to be de-opered")
+	}
+	var reply string
+	for len(admin.replies) > 0 {
+		reply = <-admin.replies
+	}
+	if !strings.HasSuffix(reply, " MODE admin :-ows") {
+		t.Errorf("Expected the operator modes to be removed, got %q", reply)
+	}
+}
+
+func TestOperAccountRegister(t *testing.T) {
+	server := newTestNickServer("")
+	server.config.Operator = map[string]*OperatorConfig{
+		"admin": {Account: "Admin"},
+	}
+	server.operators = server.config.Operators()
+
+	if err := server.RegisterAccount("ADMIN", "password", ""); err != ErrAccountReserved {
+		t.Errorf("Expected the account of an operator to be reserved, got %v", err)
+	}
+	if _, ok := server.accounts.Get("admin"); ok {
+		t.Error("Expected the account of an operator not to be registered")
+	}
+	if err := server.RegisterAccount("bob", "password", ""); err != nil {
+		t.Errorf("Expected other accounts to be registered, got %s", err)
+	}
+}
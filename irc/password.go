@@ -1,12 +1,17 @@
 package irc
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
 )
 
 var DefaultPasswordHasher = &Base64BCryptPasswordHasher{}
@@ -20,7 +25,46 @@ type PasswordHasher interface {
 type PasswordStore interface {
 	Get(username string) ([]byte, bool)
 	Set(username, password string) error
+	Delete(username string) error
 	Verify(username, password string) error
+
+	// Create atomically adds a new account, failing with ErrAccountExists
+	Create(username, password, email string) error
+	Info(username string) (AccountInfo, bool)
+	Touch(username string) error
+
+	// TLS client certificate fingerprints used by SASL EXTERNAL
+	AddCertFP(username, certfp string) error
+	DelCertFP(username, certfp string) error
+	CertFPAccount(certfp string) (string, bool)
+
+	// Scram returns the SCRAM-SHA-256 verifier derived when the
+	// password was last set
+	Scram(username string) (*ScramCredentials, bool)
+}
+
+// AccountInfo is the metadata kept alongside an account's password
+type AccountInfo struct {
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	Created   time.Time `json:"created"`
+	LastLogin time.Time `json:"lastlogin"`
+	CertFPs   []string  `json:"certfps,omitempty"`
+}
+
+var (
+	ErrCertFPExists   = errors.New("certificate fingerprint already in use")
+	ErrCertFPNotFound = errors.New("certificate fingerprint not found")
+)
+
+func removeCertFP(certfps []string, certfp string) ([]string, bool) {
+	var result []string
+	for _, fp := range certfps {
+		if fp != certfp {
+			result = append(result, fp)
+		}
+	}
+	return result, len(result) != len(certfps)
 }
 
 type PasswordStoreOpts struct {
@@ -30,6 +74,9 @@ type PasswordStoreOpts struct {
 type MemoryPasswordStore struct {
 	sync.RWMutex
 	passwords map[string][]byte
+	info      map[string]AccountInfo
+	certfps   map[string]string
+	scram     map[string]*ScramCredentials
 	hasher    PasswordHasher
 }
 
@@ -42,28 +89,481 @@ func NewMemoryPasswordStore(passwords map[string][]byte, opts PasswordStoreOpts)
 		hasher = DefaultPasswordHasher
 	}
 
-	return &MemoryPasswordStore{
-		passwords: passwords,
+	store := &MemoryPasswordStore{
+		passwords: make(map[string][]byte),
+		info:      make(map[string]AccountInfo),
+		certfps:   make(map[string]string),
+		scram:     make(map[string]*ScramCredentials),
 		hasher:    hasher,
 	}
+	for username, hash := range passwords {
+		key := CanonicalAccountName(username)
+		store.passwords[key] = hash
+		store.info[key] = AccountInfo{Name: username}
+	}
+	return store
 }
 
 func (store *MemoryPasswordStore) Get(username string) ([]byte, bool) {
 	store.RLock()
 	defer store.RUnlock()
 
-	hash, ok := store.passwords[username]
+	hash, ok := store.passwords[CanonicalAccountName(username)]
 	return hash, ok
 }
 
 func (store *MemoryPasswordStore) Set(username, password string) error {
-	// Not Implemented
+	hash, err := store.hasher.Encode([]byte(password))
+	if err != nil {
+		return err
+	}
+	scram, err := NewScramCredentials(password)
+	if err != nil {
+		return err
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	key := CanonicalAccountName(username)
+	if _, ok := store.info[key]; !ok {
+		store.info[key] = AccountInfo{Name: username, Created: time.Now()}
+	}
+	store.passwords[key] = hash
+	store.scram[key] = scram
+	return nil
+}
+
+func (store *MemoryPasswordStore) Create(username, password, email string) error {
+	hash, err := store.hasher.Encode([]byte(password))
+	if err != nil {
+		return err
+	}
+	scram, err := NewScramCredentials(password)
+	if err != nil {
+		return err
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	key := CanonicalAccountName(username)
+	if _, ok := store.passwords[key]; ok {
+		return ErrAccountExists
+	}
+	store.passwords[key] = hash
+	store.scram[key] = scram
+	store.info[key] = AccountInfo{
+		Name:    username,
+		Email:   email,
+		Created: time.Now(),
+	}
+	return nil
+}
+
+func (store *MemoryPasswordStore) Scram(username string) (*ScramCredentials, bool) {
+	store.RLock()
+	defer store.RUnlock()
+
+	scram, ok := store.scram[CanonicalAccountName(username)]
+	return scram, ok
+}
+
+func (store *MemoryPasswordStore) Info(username string) (AccountInfo, bool) {
+	store.RLock()
+	defer store.RUnlock()
+
+	info, ok := store.info[CanonicalAccountName(username)]
+	return info, ok
+}
+
+func (store *MemoryPasswordStore) Touch(username string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	key := CanonicalAccountName(username)
+	info, ok := store.info[key]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	info.LastLogin = time.Now()
+	store.info[key] = info
 	return nil
 }
 
+func (store *MemoryPasswordStore) Delete(username string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	key := CanonicalAccountName(username)
+	for _, certfp := range store.info[key].CertFPs {
+		delete(store.certfps, certfp)
+	}
+	delete(store.passwords, key)
+	delete(store.info, key)
+	delete(store.scram, key)
+	return nil
+}
+
+func (store *MemoryPasswordStore) AddCertFP(username, certfp string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	key := CanonicalAccountName(username)
+	info, ok := store.info[key]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if _, ok := store.certfps[certfp]; ok {
+		return ErrCertFPExists
+	}
+	info.CertFPs = append(info.CertFPs, certfp)
+	store.info[key] = info
+	store.certfps[certfp] = key
+	return nil
+}
+
+func (store *MemoryPasswordStore) DelCertFP(username, certfp string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	key := CanonicalAccountName(username)
+	info, ok := store.info[key]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	certfps, ok := removeCertFP(info.CertFPs, certfp)
+	if !ok {
+		return ErrCertFPNotFound
+	}
+	info.CertFPs = certfps
+	store.info[key] = info
+	delete(store.certfps, certfp)
+	return nil
+}
+
+func (store *MemoryPasswordStore) CertFPAccount(certfp string) (string, bool) {
+	store.RLock()
+	defer store.RUnlock()
+
+	account, ok := store.certfps[certfp]
+	return account, ok
+}
+
 func (store *MemoryPasswordStore) Verify(username, password string) error {
-	log.Debugf("looking up: %s", username)
-	log.Debugf("%v", store.passwords)
+	hash, ok := store.Get(username)
+	if !ok {
+		log.Debugf("username %s not found", username)
+		return fmt.Errorf("account not found: %s", username)
+	}
+
+	return store.hasher.Compare(hash, []byte(password))
+}
+
+// boltAccount is the JSON record stored for each account
+type boltAccount struct {
+	AccountInfo
+	Hash   []byte            `json:"hash"`
+	Scram  *ScramCredentials `json:"scram,omitempty"`
+	Config bool              `json:"config,omitempty"` // seeded from the config
+}
+
+// BoltPasswordStore keeps accounts in the "accounts" bucket of a
+// BoltDB database so accounts registered at runtime survive restarts.
+// Every write happens in a single transaction which BoltDB commits
+// atomically and syncs to disk, so a crash never leaves a partially
+// written account behind.
+type BoltPasswordStore struct {
+	db     *bolt.DB
+	hasher PasswordHasher
+}
+
+// NewBoltPasswordStore returns a new *BoltPasswordStore seeded with the
+// given passwords (e.g. from the config). The config is authoritative:
+// stored passwords are replaced when they differ, and accounts seeded from
+// an earlier config that are no longer in it are deleted.
+func NewBoltPasswordStore(db *bolt.DB, passwords map[string][]byte, opts PasswordStoreOpts) (*BoltPasswordStore, error) {
+	var hasher PasswordHasher
+
+	if opts.hasher != nil {
+		hasher = opts.hasher
+	} else {
+		hasher = DefaultPasswordHasher
+	}
+
+	now := time.Now()
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		seeded := make(map[string]bool)
+		for username, hash := range passwords {
+			key := []byte(CanonicalAccountName(username))
+			seeded[string(key)] = true
+
+			account, err := getBoltAccount(bucket, key)
+			if err == ErrAccountNotFound {
+				account = &boltAccount{
+					AccountInfo: AccountInfo{Name: username, Created: now},
+				}
+			} else if err != nil {
+				return err
+			} else if !account.Config {
+				log.Warnf("account %s registered at runtime is overridden by the config", username)
+			} else if !bytes.Equal(account.Hash, hash) {
+				log.Infof("account %s password changed in the config", username)
+			} else {
+				continue
+			}
+
+			if !bytes.Equal(account.Hash, hash) {
+				account.Scram = nil
+			}
+			account.Hash = hash
+			account.Config = true
+			if err := putBoltAccount(bucket, key, account); err != nil {
+				return err
+			}
+		}
+
+		var removed [][]byte
+		err := bucket.ForEach(func(key, value []byte) error {
+			account := &boltAccount{}
+			if err := json.Unmarshal(value, account); err != nil {
+				return err
+			}
+			if account.Config && !seeded[string(key)] {
+				removed = append(removed, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range removed {
+			log.Warnf("account %s removed from the config, deleting it", key)
+			if err := deleteBoltAccount(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltPasswordStore{
+		db:     db,
+		hasher: hasher,
+	}, nil
+}
+
+func getBoltAccount(bucket *bolt.Bucket, key []byte) (*boltAccount, error) {
+	value := bucket.Get(key)
+	if value == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	account := &boltAccount{}
+	if err := json.Unmarshal(value, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func putBoltAccount(bucket *bolt.Bucket, key []byte, account *boltAccount) error {
+	value, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+// update applies fn to the stored account within a single transaction
+func (store *BoltPasswordStore) update(username string, fn func(*boltAccount)) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		key := []byte(CanonicalAccountName(username))
+		account, err := getBoltAccount(bucket, key)
+		if err != nil {
+			return err
+		}
+		fn(account)
+		return putBoltAccount(bucket, key, account)
+	})
+}
+
+func (store *BoltPasswordStore) get(username string) (*boltAccount, bool) {
+	var account *boltAccount
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		account, err = getBoltAccount(
+			tx.Bucket([]byte("accounts")),
+			[]byte(CanonicalAccountName(username)),
+		)
+		return err
+	})
+	if err != nil && err != ErrAccountNotFound {
+		log.Errorf("error loading account %s: %s", username, err)
+	}
+	return account, err == nil
+}
+
+func (store *BoltPasswordStore) Get(username string) ([]byte, bool) {
+	account, ok := store.get(username)
+	if !ok {
+		return nil, false
+	}
+	return account.Hash, true
+}
+
+func (store *BoltPasswordStore) Info(username string) (AccountInfo, bool) {
+	account, ok := store.get(username)
+	if !ok {
+		return AccountInfo{}, false
+	}
+	return account.AccountInfo, true
+}
+
+func (store *BoltPasswordStore) Set(username, password string) error {
+	hash, err := store.hasher.Encode([]byte(password))
+	if err != nil {
+		return err
+	}
+	scram, err := NewScramCredentials(password)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		key := []byte(CanonicalAccountName(username))
+		account, err := getBoltAccount(bucket, key)
+		if err == ErrAccountNotFound {
+			account = &boltAccount{
+				AccountInfo: AccountInfo{Name: username, Created: time.Now()},
+			}
+		} else if err != nil {
+			return err
+		}
+		account.Hash = hash
+		account.Scram = scram
+		return putBoltAccount(bucket, key, account)
+	})
+}
+
+func (store *BoltPasswordStore) Create(username, password, email string) error {
+	hash, err := store.hasher.Encode([]byte(password))
+	if err != nil {
+		return err
+	}
+	scram, err := NewScramCredentials(password)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		key := []byte(CanonicalAccountName(username))
+		if bucket.Get(key) != nil {
+			return ErrAccountExists
+		}
+		return putBoltAccount(bucket, key, &boltAccount{
+			AccountInfo: AccountInfo{
+				Name:    username,
+				Email:   email,
+				Created: time.Now(),
+			},
+			Hash:  hash,
+			Scram: scram,
+		})
+	})
+}
+
+func (store *BoltPasswordStore) Scram(username string) (*ScramCredentials, bool) {
+	account, ok := store.get(username)
+	if !ok || account.Scram == nil {
+		return nil, false
+	}
+	return account.Scram, true
+}
+
+func (store *BoltPasswordStore) Touch(username string) error {
+	return store.update(username, func(account *boltAccount) {
+		account.LastLogin = time.Now()
+	})
+}
+
+// deleteBoltAccount deletes an account and its certificate fingerprints
+func deleteBoltAccount(tx *bolt.Tx, key []byte) error {
+	bucket := tx.Bucket([]byte("accounts"))
+	account, err := getBoltAccount(bucket, key)
+	if err == ErrAccountNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, certfp := range account.CertFPs {
+		if err := tx.Bucket([]byte("certfps")).Delete([]byte(certfp)); err != nil {
+			return err
+		}
+	}
+	return bucket.Delete(key)
+}
+
+func (store *BoltPasswordStore) Delete(username string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return deleteBoltAccount(tx, []byte(CanonicalAccountName(username)))
+	})
+}
+
+func (store *BoltPasswordStore) AddCertFP(username, certfp string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		key := []byte(CanonicalAccountName(username))
+		account, err := getBoltAccount(bucket, key)
+		if err != nil {
+			return err
+		}
+		certfps := tx.Bucket([]byte("certfps"))
+		if certfps.Get([]byte(certfp)) != nil {
+			return ErrCertFPExists
+		}
+		account.CertFPs = append(account.CertFPs, certfp)
+		if err := certfps.Put([]byte(certfp), key); err != nil {
+			return err
+		}
+		return putBoltAccount(bucket, key, account)
+	})
+}
+
+func (store *BoltPasswordStore) DelCertFP(username, certfp string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("accounts"))
+		key := []byte(CanonicalAccountName(username))
+		account, err := getBoltAccount(bucket, key)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if account.CertFPs, ok = removeCertFP(account.CertFPs, certfp); !ok {
+			return ErrCertFPNotFound
+		}
+		if err := tx.Bucket([]byte("certfps")).Delete([]byte(certfp)); err != nil {
+			return err
+		}
+		return putBoltAccount(bucket, key, account)
+	})
+}
+
+func (store *BoltPasswordStore) CertFPAccount(certfp string) (string, bool) {
+	var account string
+	store.db.View(func(tx *bolt.Tx) error {
+		account = string(tx.Bucket([]byte("certfps")).Get([]byte(certfp)))
+		return nil
+	})
+	return account, account != ""
+}
+
+func (store *BoltPasswordStore) Verify(username, password string) error {
 	hash, ok := store.Get(username)
 	if !ok {
 		log.Debugf("username %s not found", username)
@@ -81,9 +581,6 @@ func (hasher *Base64BCryptPasswordHasher) Decode(encoded []byte) (decoded []byte
 		return
 	}
 	decoded = make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
-	log.Debugf("Decode:")
-	log.Debugf("decoded: %v", decoded)
-	log.Debugf("encoded: %v", encoded)
 	_, err = base64.StdEncoding.Decode(decoded, encoded)
 	return
 }
@@ -93,20 +590,17 @@ func (hasher *Base64BCryptPasswordHasher) Encode(password []byte) (encoded []byt
 		err = fmt.Errorf("empty password")
 		return
 	}
-	bcrypted, err := bcrypt.GenerateFromPassword(password, bcrypt.MinCost)
+	bcrypted, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
 	if err != nil {
 		return
 	}
+	encoded = make([]byte, base64.StdEncoding.EncodedLen(len(bcrypted)))
 	base64.StdEncoding.Encode(encoded, bcrypted)
 	return
 }
 
 func (hasher *Base64BCryptPasswordHasher) Compare(encoded, password []byte) error {
-	log.Debugf("encoded: %s", encoded)
-	log.Debugf("password: %s", password)
 	decoded, err := hasher.Decode(encoded)
-	log.Debugf("decoded: %s", decoded)
-	log.Debugf("err: %s", err)
 	if err != nil {
 		return err
 	}
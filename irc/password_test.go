@@ -0,0 +1,181 @@
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testPasswordStore(t *testing.T, store PasswordStore) {
+	if err := store.Verify("foo", "bar"); err == nil {
+		t.Error("Expected unknown account to fail verification")
+	}
+
+	if err := store.Set("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify("foo", "bar"); err != nil {
+		t.Errorf("Expected account to verify: %s", err)
+	}
+	if err := store.Verify("foo", "baz"); err == nil {
+		t.Error("Expected wrong password to fail verification")
+	}
+
+	if err := store.Set("foo", "baz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify("foo", "baz"); err != nil {
+		t.Errorf("Expected changed password to verify: %s", err)
+	}
+	if err := store.Verify("FOO", "baz"); err != nil {
+		t.Errorf("Expected account names to be case-insensitive: %s", err)
+	}
+
+	if err := store.Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get("foo"); ok {
+		t.Error("Expected account to be deleted")
+	}
+
+	if err := store.Create("Bar", "baz", "bar@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create("bar", "qux", ""); err != ErrAccountExists {
+		t.Errorf("Expected ErrAccountExists, got %v", err)
+	}
+	if err := store.Verify("bar", "baz"); err != nil {
+		t.Errorf("Expected created account to verify: %s", err)
+	}
+
+	info, ok := store.Info("bar")
+	if !ok {
+		t.Fatal("Expected account info")
+	}
+	if info.Name != "Bar" || info.Email != "bar@example.com" {
+		t.Errorf("Unexpected account info: %+v", info)
+	}
+	if info.Created.IsZero() || !info.LastLogin.IsZero() {
+		t.Errorf("Unexpected account times: %+v", info)
+	}
+
+	if err := store.Touch("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := store.Info("bar"); info.LastLogin.IsZero() {
+		t.Error("Expected last login to be recorded")
+	}
+	if err := store.Touch("foo"); err != ErrAccountNotFound {
+		t.Errorf("Expected ErrAccountNotFound, got %v", err)
+	}
+
+	if err := store.AddCertFP("bar", "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCertFP("foo", "abcd"); err != ErrAccountNotFound {
+		t.Errorf("Expected ErrAccountNotFound, got %v", err)
+	}
+	if account, ok := store.CertFPAccount("abcd"); !ok || account != "bar" {
+		t.Errorf("Expected fingerprint to map to bar, got %q", account)
+	}
+	if err := store.AddCertFP("bar", "abcd"); err != ErrCertFPExists {
+		t.Errorf("Expected ErrCertFPExists, got %v", err)
+	}
+	if info, _ := store.Info("bar"); len(info.CertFPs) != 1 {
+		t.Errorf("Expected one fingerprint, got %v", info.CertFPs)
+	}
+	if err := store.DelCertFP("bar", "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DelCertFP("bar", "abcd"); err != ErrCertFPNotFound {
+		t.Errorf("Expected ErrCertFPNotFound, got %v", err)
+	}
+	if _, ok := store.CertFPAccount("abcd"); ok {
+		t.Error("Expected fingerprint to be removed")
+	}
+
+	if err := store.AddCertFP("bar", "ef01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.CertFPAccount("ef01"); ok {
+		t.Error("Expected fingerprint to be removed with its account")
+	}
+}
+
+func TestMemoryPasswordStore(t *testing.T) {
+	testPasswordStore(t, NewMemoryPasswordStore(
+		make(map[string][]byte), PasswordStoreOpts{},
+	))
+}
+
+func TestBoltPasswordStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(filepath.Join(dir, "eris.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store, err := NewBoltPasswordStore(db, make(map[string][]byte), PasswordStoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testPasswordStore(t, store)
+}
+
+func TestBoltPasswordStoreConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(filepath.Join(dir, "eris.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store, err := NewBoltPasswordStore(db, map[string][]byte{"foo": []byte("old")}, PasswordStoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create("bar", "bar", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewBoltPasswordStore(db, map[string][]byte{"foo": []byte("new")}, PasswordStoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ := store.Get("foo"); string(hash) != "new" {
+		t.Errorf("Expected the config password to replace the stored one, got %q", hash)
+	}
+	if _, ok := store.Scram("foo"); ok {
+		t.Error("Expected the stale SCRAM verifier to be dropped")
+	}
+
+	store, err = NewBoltPasswordStore(db, make(map[string][]byte), PasswordStoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get("foo"); ok {
+		t.Error("Expected the account removed from the config to be deleted")
+	}
+	if _, ok := store.Get("bar"); !ok {
+		t.Error("Expected the account registered at runtime to be kept")
+	}
+}
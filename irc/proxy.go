@@ -0,0 +1,235 @@
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PROXY_TIMEOUT = 5 * time.Second // how long a proxy may take to send its header
+
+	proxyV1MaxLen = 107 // maximum length of a v1 header including CRLF
+	proxyV2Local  = 0x20
+	proxyV2Proxy  = 0x21
+	proxyV2TCP4   = 0x11
+	proxyV2TCP6   = 0x21
+	proxyV2SSL    = 0x20 // PP2_TYPE_SSL
+	proxyV2Client = 0x01 // PP2_CLIENT_SSL
+)
+
+var (
+	ErrProxyHeader = errors.New("invalid PROXY protocol header")
+
+	proxyV1Signature = []byte("PROXY")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ProxyListener accepts connections that start with a PROXY protocol
+// (https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt) header
+// from trusted proxies. Connections from other sources are accepted as
+// they are.
+type ProxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func NewProxyListener(listener net.Listener, trusted []*net.IPNet) *ProxyListener {
+	return &ProxyListener{Listener: listener, trusted: trusted}
+}
+
+func (listener *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !IsTrustedAddr(conn.RemoteAddr(), listener.trusted) {
+		return conn, nil
+	}
+	return NewProxyConn(conn), nil
+}
+
+// IsTrustedAddr returns true if the IP of addr is in one of the networks
+func IsTrustedAddr(addr net.Addr, networks []*net.IPNet) bool {
+	ip := net.ParseIP(IPString(addr).String())
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyConn is a connection from a proxy. The header is read on first
+// use and the connection then reports the address of the client the
+// proxy accepted the connection from.
+type ProxyConn struct {
+	net.Conn
+	once       sync.Once
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	secure     bool
+	err        error
+}
+
+func NewProxyConn(conn net.Conn) *ProxyConn {
+	return &ProxyConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+func (conn *ProxyConn) init() {
+	conn.once.Do(func() {
+		conn.remoteAddr = conn.Conn.RemoteAddr()
+
+		conn.Conn.SetReadDeadline(time.Now().Add(PROXY_TIMEOUT))
+		conn.err = conn.readHeader()
+		conn.Conn.SetReadDeadline(time.Time{})
+
+		if conn.err != nil {
+			log.Warnf("error reading PROXY header from %s: %s", conn.Conn.RemoteAddr(), conn.err)
+		}
+	})
+}
+
+func (conn *ProxyConn) readHeader() error {
+	signature, err := conn.reader.Peek(len(proxyV1Signature))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(signature, proxyV1Signature) {
+		return conn.readV1Header()
+	}
+
+	signature, err = conn.reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(signature, proxyV2Signature) {
+		return conn.readV2Header()
+	}
+	return ErrProxyHeader
+}
+
+// PROXY TCP4|TCP6|UNKNOWN <src> <dst> <srcport> <dstport>\r\n
+func (conn *ProxyConn) readV1Header() error {
+	line, err := conn.reader.ReadSlice('\n')
+	if err != nil || len(line) > proxyV1MaxLen {
+		return ErrProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return ErrProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil
+	case "TCP4", "TCP6":
+	default:
+		return ErrProxyHeader
+	}
+
+	if len(fields) != 6 {
+		return ErrProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return ErrProxyHeader
+	}
+	conn.remoteAddr = &net.TCPAddr{IP: ip, Port: int(port)}
+	return nil
+}
+
+func (conn *ProxyConn) readV2Header() error {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(conn.reader, header); err != nil {
+		return err
+	}
+	command, family := header[12], header[13]
+	data := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(conn.reader, data); err != nil {
+		return err
+	}
+
+	switch command {
+	case proxyV2Local:
+		// health checks from the proxy itself
+		return nil
+	case proxyV2Proxy:
+	default:
+		return ErrProxyHeader
+	}
+
+	var tlvs []byte
+	switch family {
+	case proxyV2TCP4:
+		if len(data) < 12 {
+			return ErrProxyHeader
+		}
+		conn.remoteAddr = &net.TCPAddr{
+			IP:   net.IP(data[0:4]),
+			Port: int(binary.BigEndian.Uint16(data[8:])),
+		}
+		tlvs = data[12:]
+	case proxyV2TCP6:
+		if len(data) < 36 {
+			return ErrProxyHeader
+		}
+		conn.remoteAddr = &net.TCPAddr{
+			IP:   net.IP(data[0:16]),
+			Port: int(binary.BigEndian.Uint16(data[32:])),
+		}
+		tlvs = data[36:]
+	default:
+		// unsupported address family, keep the proxy's address
+		return nil
+	}
+
+	for len(tlvs) >= 3 {
+		kind, length := tlvs[0], int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < 3+length {
+			return ErrProxyHeader
+		}
+		value := tlvs[3 : 3+length]
+		if kind == proxyV2SSL && length > 0 && value[0]&proxyV2Client != 0 {
+			conn.secure = true
+		}
+		tlvs = tlvs[3+length:]
+	}
+	return nil
+}
+
+func (conn *ProxyConn) Read(p []byte) (int, error) {
+	conn.init()
+	if conn.err != nil {
+		return 0, conn.err
+	}
+	return conn.reader.Read(p)
+}
+
+// RemoteAddr returns the address of the client connected to the proxy
+func (conn *ProxyConn) RemoteAddr() net.Addr {
+	conn.init()
+	return conn.remoteAddr
+}
+
+// Secure returns true if the client connected to the proxy with TLS
+func (conn *ProxyConn) Secure() bool {
+	conn.init()
+	return conn.secure
+}
@@ -0,0 +1,126 @@
+package irc
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyPipe(t *testing.T, header []byte) *ProxyConn {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		client.Write(header)
+		client.Write([]byte("NICK foo\r\n"))
+	}()
+	return NewProxyConn(server)
+}
+
+func TestProxyConnV1(t *testing.T) {
+	conn := proxyPipe(t, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 6667\r\n"))
+	if addr := conn.RemoteAddr().String(); addr != "192.0.2.1:56324" {
+		t.Errorf("Expected 192.0.2.1:56324, got %s", addr)
+	}
+	if conn.Secure() {
+		t.Error("Expected connection not to be secure")
+	}
+
+	socket := NewSocket(conn)
+	if line, err := socket.Read(); err != nil || line != "NICK foo" {
+		t.Errorf("Expected NICK foo, got %q (%v)", line, err)
+	}
+}
+
+func TestProxyConnV2(t *testing.T) {
+	addrs := make([]byte, 36)
+	copy(addrs, net.ParseIP("2001:db8::1"))
+	copy(addrs[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(addrs[32:], 56324)
+	binary.BigEndian.PutUint16(addrs[34:], 6667)
+	// PP2_TYPE_SSL with the PP2_CLIENT_SSL bit set
+	ssl := []byte{proxyV2SSL, 0, 5, proxyV2Client, 0, 0, 0, 0}
+
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, proxyV2Proxy, proxyV2TCP6, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)+len(ssl)))
+	header = append(append(header, addrs...), ssl...)
+
+	conn := proxyPipe(t, header)
+	if addr := conn.RemoteAddr().String(); addr != "[2001:db8::1]:56324" {
+		t.Errorf("Expected [2001:db8::1]:56324, got %s", addr)
+	}
+	if !conn.Secure() || !IsSecure(conn) {
+		t.Error("Expected connection to be secure")
+	}
+
+	socket := NewSocket(conn)
+	if line, err := socket.Read(); err != nil || line != "NICK foo" {
+		t.Errorf("Expected NICK foo, got %q (%v)", line, err)
+	}
+}
+
+func TestProxyConnInvalid(t *testing.T) {
+	conn := proxyPipe(t, []byte("USER foo 0 * :Foo\r\n"))
+	if _, err := NewSocket(conn).Read(); err == nil {
+		t.Error("Expected an error without a PROXY header")
+	}
+}
+
+func TestProxyConfigTrustedNets(t *testing.T) {
+	conf := &ProxyConfig{Trusted: []string{"10.0.0.0/8", "127.0.0.1", "::1"}}
+	trusted, err := conf.TrustedNets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, expected := range map[string]bool{
+		"10.1.2.3:1234":  true,
+		"127.0.0.1:1234": true,
+		"127.0.0.2:1234": false,
+		"[::1]:1234":     true,
+	} {
+		tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+		if IsTrustedAddr(tcpAddr, trusted) != expected {
+			t.Errorf("Expected %s trusted to be %v", addr, expected)
+		}
+	}
+
+	conf.Trusted = []string{"proxy.example.com"}
+	if _, err := conf.TrustedNets(); err == nil {
+		t.Error("Expected an error for an invalid trusted proxy")
+	}
+}
+
+func TestAcceptorProxyTLS(t *testing.T) {
+	server := newTestNickServer("")
+	server.newConns = make(chan net.Conn)
+	server.xlines, _ = NewXLines(NewMemoryXLineStore())
+
+	listener := NewWSListener(nil, nil)
+	defer listener.Close()
+	go server.acceptor(listener)
+
+	// a proxy that never sends its header must not hold up other clients
+	stalled, remote := net.Pipe()
+	defer remote.Close()
+	listener.conns <- tls.Server(NewProxyConn(stalled), &tls.Config{})
+
+	conn := proxyPipe(t, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 6667\r\n"))
+	go func() {
+		select {
+		case listener.conns <- conn:
+		case <-listener.done:
+		}
+	}()
+
+	select {
+	case admitted := <-server.newConns:
+		if admitted != conn {
+			t.Errorf("Expected the proxied client to be admitted, got %s", admitted.RemoteAddr())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the acceptor not to wait for a PROXY header behind TLS")
+	}
+}
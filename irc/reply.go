@@ -22,6 +22,31 @@ func (code NumericCode) String() string {
 	return fmt.Sprintf("%03d", code)
 }
 
+const (
+	// ServerTimeFormat is the format of the `time` tag (server-time)
+	ServerTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+var (
+	// string replies that are tagged with the time they were sent
+	serverTimeCodes = map[StringCode]bool{
+		JOIN:    true,
+		KICK:    true,
+		MODE:    true,
+		NOTICE:  true,
+		PART:    true,
+		PRIVMSG: true,
+		QUIT:    true,
+		TAGMSG:  true,
+		TOPIC:   true,
+	}
+)
+
+// FormatServerTime returns the value of the `time` tag for t
+func FormatServerTime(t time.Time) string {
+	return t.UTC().Format(ServerTimeFormat)
+}
+
 func NewStringReply(source Identifiable, code StringCode,
 	format string, args ...interface{}) string {
 	var header string
@@ -36,6 +61,9 @@ func NewStringReply(source Identifiable, code StringCode,
 	} else {
 		message = format
 	}
+	if serverTimeCodes[code] {
+		return WithTags(header+message, Tags{"time": FormatServerTime(time.Now())})
+	}
 	return header + message
 }
 
@@ -107,6 +135,10 @@ func RplNotice(source Identifiable, target Identifiable, message Text) string {
 	return NewStringReply(source, NOTICE, "%s :%s", target.Nick(), message)
 }
 
+func RplTagMsg(source Identifiable, target Identifiable, tags Tags) string {
+	return WithTags(NewStringReply(source, TAGMSG, "%s", target.Nick()), tags)
+}
+
 func RplNick(source Identifiable, newNick Name) string {
 	return NewStringReply(source, NICK, newNick.String())
 }
@@ -163,6 +195,17 @@ func RplKill(client *Client, target *Client, comment Text) string {
 		"%s :%s", target.Nick(), comment)
 }
 
+// FAIL <command> <code> [<context>] :<description>
+func RplFail(client *Client, command StringCode, code string, context string,
+	description string) string {
+	if context == "" {
+		return NewStringReply(client.server, FAIL, "%s %s :%s",
+			command, code, description)
+	}
+	return NewStringReply(client.server, FAIL, "%s %s %s :%s",
+		command, code, context, description)
+}
+
 func RplCap(client *Client, subCommand CapSubCommand, arg interface{}) string {
 	// client.server needs to be here to workaround a parsing bug in weechat 1.4
 	// and let it connect to the server (otherwise it doesn't respond to the CAP
@@ -170,6 +213,11 @@ func RplCap(client *Client, subCommand CapSubCommand, arg interface{}) string {
 	return NewStringReply(client.server, CAP, "%s %s :%s", client.Nick(), subCommand, arg)
 }
 
+// RplCapMore is a CAP reply that is continued on the next line
+func RplCapMore(client *Client, subCommand CapSubCommand, arg interface{}) string {
+	return NewStringReply(client.server, CAP, "%s %s * :%s", client.Nick(), subCommand, arg)
+}
+
 // numeric replies
 
 func (target *Client) RplWelcome() {
@@ -206,6 +254,21 @@ func (target *Client) RplMyInfo() {
 	)
 }
 
+// <token>... :are supported by this server
+func (target *Client) RplISupport() {
+	const suffix = ":are supported by this server"
+	baseLen := len(NewNumericReply(target, RPL_ISUPPORT, suffix))
+	tokens := target.server.isupport.Tokens()
+	for _, line := range splitISupportTokens(tokens, baseLen) {
+		target.NumericReply(
+			RPL_ISUPPORT,
+			"%s %s",
+			strings.Join(line, " "),
+			suffix,
+		)
+	}
+}
+
 func (target *Client) RplUModeIs(client *Client) {
 	target.NumericReply(RPL_UMODEIS, client.ModeString())
 }
@@ -243,21 +306,27 @@ func (target *Client) RplRehashing() {
 	target.NumericReply(
 		RPL_REHASHING,
 		"%s :Rehashing",
-		target.server.config.Name(),
+		target.server.Config().Name(),
 	)
 }
 
 func (target *Client) RplWhois(client *Client) {
 	target.RplWhoisUser(client)
-	if client.flags[Operator] {
+	if client.HasFlag(Operator) {
 		target.RplWhoisOperator(client)
 	}
 	target.RplWhoisIdle(client)
 	target.RplWhoisChannels(client)
 
-	if client.flags[SecureConn] {
+	if client.HasFlag(SecureConn) {
 		target.RplWhoisSecure(client)
 	}
+	if client.certfp != "" && (target == client || target.HasPrivilege(PrivSeeHosts)) {
+		target.RplWhoisCertFP(client)
+	}
+	if client.dnsbl != nil && target.HasPrivilege(PrivSeeHosts) {
+		target.RplWhoisDNSBL(client)
+	}
 	target.RplWhoisServer(client)
 	target.RplWhoisLoggedIn(client)
 	target.RplEndOfWhois(client)
@@ -266,7 +335,7 @@ func (target *Client) RplWhois(client *Client) {
 func (target *Client) RplWhoisUser(client *Client) {
 	var clientHost Name
 
-	if target.flags[Operator] {
+	if target.HasPrivilege(PrivSeeHosts) {
 		clientHost = client.hostname
 	} else {
 		clientHost = client.hostmask
@@ -283,6 +352,11 @@ func (target *Client) RplWhoisUser(client *Client) {
 }
 
 func (target *Client) RplWhoisOperator(client *Client) {
+	if class := client.OperClass(); class != nil && class.Name != "" {
+		target.NumericReply(RPL_WHOISOPERATOR,
+			"%s :is an IRC operator (%s)", client.Nick(), class.Name)
+		return
+	}
 	target.NumericReply(RPL_WHOISOPERATOR,
 		"%s :is an IRC operator", client.Nick())
 }
@@ -295,6 +369,22 @@ func (target *Client) RplWhoisSecure(client *Client) {
 	)
 }
 
+func (target *Client) RplWhoisCertFP(client *Client) {
+	target.NumericReply(
+		RPL_WHOISCERTFP,
+		"%s :has client certificate fingerprint %s",
+		client.Nick(), client.certfp,
+	)
+}
+
+func (target *Client) RplWhoisDNSBL(client *Client) {
+	target.NumericReply(
+		RPL_WHOISSPECIAL,
+		"%s :is listed in DNSBL %s",
+		client.Nick(), client.dnsbl.Zone,
+	)
+}
+
 func (target *Client) RplWhoisIdle(client *Client) {
 	target.NumericReply(RPL_WHOISIDLE,
 		"%s %d %d :seconds idle, signon time",
@@ -342,7 +432,7 @@ func (target *Client) RplChannelModeIs(channel *Channel) {
 func (target *Client) RplWhoReply(channel *Channel, client *Client) {
 	var clientHost Name
 
-	if target.flags[Operator] {
+	if target.HasPrivilege(PrivSeeHosts) {
 		clientHost = client.hostname
 	} else {
 		clientHost = client.hostmask
@@ -351,18 +441,18 @@ func (target *Client) RplWhoReply(channel *Channel, client *Client) {
 	channelName := "*"
 	flags := ""
 
-	if client.flags[Away] {
+	if client.HasFlag(Away) {
 		flags = "G"
 	} else {
 		flags = "H"
 	}
-	if client.flags[Operator] {
+	if client.HasFlag(Operator) {
 		flags += "*"
 	}
 
 	if channel != nil {
 		channelName = channel.name.String()
-		if target.capabilities[MultiPrefix] {
+		if target.HasCapability(MultiPrefix) {
 			if channel.members.Get(client).Has(ChannelOperator) {
 				flags += "@"
 			}
@@ -544,13 +634,12 @@ func (target *Client) RplLUserClient() {
 		target.server.clients.Count(),
 		// TODO: count global invisible users
 		0,
-		// TODO: count global server connections
-		1,
+		1+len(target.server.RemoteServers()),
 	)
 }
 
 func (target *Client) RplLUserUnknown() {
-	nUnknown := target.server.connections.Value() - target.server.clients.Count()
+	nUnknown := target.server.connections.Value() - target.server.LocalClientCount()
 
 	if nUnknown == 0 {
 		return
@@ -579,7 +668,7 @@ func (target *Client) RplLUserChannels() {
 func (target *Client) RplLUserOp() {
 	nOperators := 0
 	target.server.clients.Range(func(_ Name, client *Client) bool {
-		if client.flags[Operator] {
+		if client.HasFlag(Operator) {
 			nOperators++
 		}
 		return true
@@ -601,16 +690,15 @@ func (target *Client) RplLUserMe() {
 	target.NumericReply(
 		RPL_LUSERME,
 		"I have %d clients and %d servers",
-		target.server.clients.Count(),
-		// TODO: count server connections
-		1,
+		target.server.LocalClientCount(),
+		target.server.LinkCount(),
 	)
 }
 
 func (target *Client) RplWhoWasUser(whoWas *WhoWas) {
 	var whoWasHost Name
 
-	if target.flags[Operator] {
+	if target.HasPrivilege(PrivSeeHosts) {
 		whoWasHost = whoWas.hostname
 	} else {
 		whoWasHost = whoWas.hostmask
@@ -631,6 +719,34 @@ func (target *Client) RplEndOfWhoWas(nickname Name) {
 		"%s :End of WHOWAS", nickname)
 }
 
+func (target *Client) RplHostHidden() {
+	target.NumericReply(RPL_HOSTHIDDEN,
+		"%s :is now your displayed host", target.hostmask)
+}
+
+func (target *Client) RplStatsXLine(xline *XLine) {
+	if xline.Type == DLine {
+		target.NumericReply(RPL_STATSDLINE,
+			"%s %s :%s", xline.Type, xline.Mask, xline.Description())
+		return
+	}
+	userhost := strings.SplitN(xline.Mask, "@", 2)
+	target.NumericReply(RPL_STATSKLINE,
+		"%s %s * %s :%s", xline.Type, userhost[1], userhost[0], xline.Description())
+}
+
+func (target *Client) RplStatsUptime() {
+	uptime := time.Since(target.server.ctime)
+	target.NumericReply(RPL_STATSUPTIME,
+		":Server Up %d days %d:%02d:%02d", int(uptime.Hours())/24,
+		int(uptime.Hours())%24, int(uptime.Minutes())%60, int(uptime.Seconds())%60)
+}
+
+func (target *Client) RplEndOfStats(query string) {
+	target.NumericReply(RPL_ENDOFSTATS,
+		"%s :End of STATS report", query)
+}
+
 //
 // errors (also numeric)
 //
@@ -640,11 +756,20 @@ func (target *Client) ErrAlreadyRegistered() {
 		":You may not reregister")
 }
 
+func (target *Client) ErrNickReserved(nick Name) {
+	target.NumericReply(ERR_NICKNAMEINUSE,
+		"%s :Nickname is reserved by a registered account", nick)
+}
+
 func (target *Client) ErrNickNameInUse(nick Name) {
 	target.NumericReply(ERR_NICKNAMEINUSE,
 		"%s :Nickname is already in use", nick)
 }
 
+func (target *Client) ErrInputTooLong() {
+	target.NumericReply(ERR_INPUTTOOLONG, ":Input line too long")
+}
+
 func (target *Client) ErrUnknownCommand(code StringCode) {
 	target.NumericReply(ERR_UNKNOWNCOMMAND,
 		"%s :Unknown command", code)
@@ -699,10 +824,19 @@ func (target *Client) ErrNoChanModes(channel *Channel) {
 		"%s :Channel doesn't support modes", channel)
 }
 
+func (target *Client) ErrNoOperHost() {
+	target.NumericReply(ERR_NOOPERHOST, ":No O-lines for your host")
+}
+
 func (target *Client) ErrNoPrivileges() {
 	target.NumericReply(ERR_NOPRIVILEGES, ":Permission Denied")
 }
 
+func (target *Client) ErrYoureBannedCreep(reason string) {
+	target.NumericReply(ERR_YOUREBANNEDCREEP,
+		":You are banned from this server (%s)", reason)
+}
+
 func (target *Client) ErrRestricted() {
 	target.NumericReply(ERR_RESTRICTED, ":Your connection is restricted!")
 }
@@ -802,7 +936,7 @@ func (target *Client) RplLoggedIn(authcid string) {
 
 func (target *Client) RplLoggedOut() {
 	target.NumericReply(
-		RPL_LOGGEDIN,
+		RPL_LOGGEDOUT,
 		"%s :You are now logged out",
 		target,
 	)
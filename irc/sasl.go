@@ -5,6 +5,9 @@ import (
 	"sync"
 )
 
+// SaslMechanisms are the supported SASL mechanisms
+var SaslMechanisms = []string{"PLAIN", "EXTERNAL", SCRAM_MECHANISM}
+
 type SaslState struct {
 	sync.RWMutex
 
@@ -12,6 +15,7 @@ type SaslState struct {
 
 	buffer *bytes.Buffer
 	mech   string
+	scram  *ScramExchange
 
 	authcid string
 }
@@ -27,6 +31,7 @@ func (s *SaslState) Reset() {
 	s.started = false
 	s.buffer.Reset()
 	s.mech = ""
+	s.scram = nil
 	s.authcid = ""
 }
 
@@ -37,11 +42,42 @@ func (s *SaslState) Started() bool {
 	return s.started
 }
 
-func (s *SaslState) Start() {
+func (s *SaslState) Start(mech string) {
 	s.Lock()
 	defer s.Unlock()
 
 	s.started = true
+	s.mech = mech
+}
+
+func (s *SaslState) Mech() string {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.mech
+}
+
+func (s *SaslState) SetScram(scram *ScramExchange) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.scram = scram
+}
+
+func (s *SaslState) Scram() *ScramExchange {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.scram
+}
+
+// ResetBuffer discards the buffered client response between steps of a
+// multi-step mechanism.
+func (s *SaslState) ResetBuffer() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.buffer.Reset()
 }
 
 func (s *SaslState) WriteString(data string) {
@@ -72,6 +108,7 @@ func (s *SaslState) Login(authcid string) {
 	s.started = false
 	s.buffer.Reset()
 	s.mech = ""
+	s.scram = nil
 
 	s.authcid = authcid
 }
@@ -0,0 +1,244 @@
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	SCRAM_ITERATIONS  = 4096
+	SCRAM_SALT_LEN    = 16
+	SCRAM_NONCE_LEN   = 18
+	SCRAM_MECHANISM   = "SCRAM-SHA-256"
+	scramClientKeyMsg = "Client Key"
+	scramServerKeyMsg = "Server Key"
+)
+
+var (
+	// key deriving the salts answered for unknown accounts, so they are
+	// stable for each name but cannot be told apart from real ones
+	scramFakeKey = make([]byte, sha256.Size)
+)
+
+func init() {
+	if _, err := rand.Read(scramFakeKey); err != nil {
+		panic(err)
+	}
+}
+
+var (
+	ErrScramInvalidMessage = errors.New("invalid SCRAM message")
+	ErrScramChannelBinding = errors.New("channel binding is not supported")
+	ErrScramNonceMismatch  = errors.New("nonce mismatch")
+	ErrScramInvalidProof   = errors.New("invalid client proof")
+)
+
+// ScramCredentials is the salted verifier stored for an account so the
+// server can check a SCRAM-SHA-256 proof without knowing the password.
+type ScramCredentials struct {
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	StoredKey  []byte `json:"storedkey"`
+	ServerKey  []byte `json:"serverkey"`
+}
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func scramHash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// NewScramCredentials derives SCRAM-SHA-256 credentials for password
+// using a new random salt.
+func NewScramCredentials(password string) (*ScramCredentials, error) {
+	salt := make([]byte, SCRAM_SALT_LEN)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	salted := pbkdf2.Key([]byte(password), salt, SCRAM_ITERATIONS, sha256.Size, sha256.New)
+	return &ScramCredentials{
+		Salt:       salt,
+		Iterations: SCRAM_ITERATIONS,
+		StoredKey:  scramHash(scramHMAC(salted, scramClientKeyMsg)),
+		ServerKey:  scramHMAC(salted, scramServerKeyMsg),
+	}, nil
+}
+
+// scramFakeCredentials returns credentials for an unknown account with a
+// deterministic salt that no proof will match.
+func scramFakeCredentials(username string) *ScramCredentials {
+	salt := scramHMAC(scramFakeKey, CanonicalAccountName(username))
+	return &ScramCredentials{
+		Salt:       salt[:SCRAM_SALT_LEN],
+		Iterations: SCRAM_ITERATIONS,
+		StoredKey:  scramHMAC(scramFakeKey, "stored key"),
+		ServerKey:  scramHMAC(scramFakeKey, "server key"),
+	}
+}
+
+// parseScramAttributes parses a comma separated list of k=v attributes
+func parseScramAttributes(message string) (map[byte]string, error) {
+	attrs := make(map[byte]string)
+	for _, attr := range strings.Split(message, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			return nil, ErrScramInvalidMessage
+		}
+		attrs[attr[0]] = attr[2:]
+	}
+	return attrs, nil
+}
+
+// ScramExchange is the server side of a SCRAM-SHA-256 exchange.
+// Each call to Next processes one client response and returns the next
+// challenge until the exchange is done.
+type ScramExchange struct {
+	accounts PasswordStore
+	step     int
+
+	account     string
+	credentials *ScramCredentials
+	gs2Header   string
+	nonce       string
+	authMessage string
+}
+
+func NewScramExchange(accounts PasswordStore) *ScramExchange {
+	return &ScramExchange{accounts: accounts}
+}
+
+// Account returns the account being authenticated
+func (scram *ScramExchange) Account() string {
+	return scram.account
+}
+
+// Next processes the client's response returning the server's challenge
+// and whether the client has been authenticated.
+func (scram *ScramExchange) Next(response []byte) (challenge []byte, done bool, err error) {
+	switch scram.step {
+	case 0:
+		challenge, err = scram.clientFirst(string(response))
+	case 1:
+		challenge, err = scram.clientFinal(string(response))
+	case 2:
+		// client acknowledged the server signature
+		if len(response) != 0 {
+			err = ErrScramInvalidMessage
+		}
+		done = true
+	default:
+		err = ErrScramInvalidMessage
+	}
+	scram.step++
+	return
+}
+
+// clientFirst handles "gs2-header client-first-message-bare"
+func (scram *ScramExchange) clientFirst(message string) ([]byte, error) {
+	parts := strings.SplitN(message, ",", 3)
+	if len(parts) != 3 {
+		return nil, ErrScramInvalidMessage
+	}
+
+	switch parts[0] {
+	case "n", "y":
+	case "":
+		return nil, ErrScramInvalidMessage
+	default:
+		return nil, ErrScramChannelBinding
+	}
+
+	scram.gs2Header = parts[0] + "," + parts[1] + ","
+	bare := parts[2]
+
+	attrs, err := parseScramAttributes(bare)
+	if err != nil {
+		return nil, err
+	}
+	username, clientNonce := attrs['n'], attrs['r']
+	if username == "" || clientNonce == "" {
+		return nil, ErrScramInvalidMessage
+	}
+	username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(username)
+
+	authzid := strings.TrimPrefix(parts[1], "a=")
+	if authzid != "" && CanonicalAccountName(authzid) != CanonicalAccountName(username) {
+		return nil, errors.New("authzid and authcid should be the same")
+	}
+
+	// Unknown accounts carry on with fake credentials and fail on the
+	// proof the same way as a wrong password.
+	credentials, ok := scram.accounts.Scram(username)
+	if !ok {
+		credentials = scramFakeCredentials(username)
+	}
+
+	serverNonce := make([]byte, SCRAM_NONCE_LEN)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+
+	scram.account = username
+	scram.credentials = credentials
+	scram.nonce = clientNonce + base64.RawStdEncoding.EncodeToString(serverNonce)
+
+	serverFirst := fmt.Sprintf("r=%s,s=%s,i=%d",
+		scram.nonce,
+		base64.StdEncoding.EncodeToString(credentials.Salt),
+		credentials.Iterations,
+	)
+	scram.authMessage = bare + "," + serverFirst
+	return []byte(serverFirst), nil
+}
+
+// clientFinal handles "c=channel-binding,r=nonce,p=proof"
+func (scram *ScramExchange) clientFinal(message string) ([]byte, error) {
+	index := strings.LastIndex(message, ",p=")
+	if index < 0 {
+		return nil, ErrScramInvalidMessage
+	}
+	withoutProof := message[:index]
+
+	attrs, err := parseScramAttributes(withoutProof)
+	if err != nil {
+		return nil, err
+	}
+	if attrs['c'] != base64.StdEncoding.EncodeToString([]byte(scram.gs2Header)) {
+		return nil, ErrScramChannelBinding
+	}
+	if attrs['r'] != scram.nonce {
+		return nil, ErrScramNonceMismatch
+	}
+
+	proof, err := base64.StdEncoding.DecodeString(message[index+3:])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, ErrScramInvalidMessage
+	}
+
+	scram.authMessage += "," + withoutProof
+
+	// ClientKey = ClientProof XOR HMAC(StoredKey, AuthMessage)
+	signature := scramHMAC(scram.credentials.StoredKey, scram.authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ signature[i]
+	}
+	if !hmac.Equal(scramHash(clientKey), scram.credentials.StoredKey) {
+		return nil, ErrScramInvalidProof
+	}
+
+	serverSignature := scramHMAC(scram.credentials.ServerKey, scram.authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
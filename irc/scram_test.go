@@ -0,0 +1,143 @@
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// scramClient performs the client side of an exchange for the tests
+func scramClient(t *testing.T, scram *ScramExchange, username, password string) ([]byte, error) {
+	clientFirstBare := "n=" + username + ",r=rOprNGfwEbeRWgbNEkqO"
+	serverFirst, _, err := scram.Next([]byte("n,," + clientFirstBare))
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := parseScramAttributes(string(serverFirst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(attrs['r'], "rOprNGfwEbeRWgbNEkqO") {
+		t.Fatalf("Expected server nonce to extend client nonce, got %q", attrs['r'])
+	}
+	salt, _ := base64.StdEncoding.DecodeString(attrs['s'])
+	iterations, _ := strconv.Atoi(attrs['i'])
+
+	withoutProof := "c=biws,r=" + attrs['r']
+	authMessage := clientFirstBare + "," + string(serverFirst) + "," + withoutProof
+
+	salted := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+	clientKey := scramHMAC(salted, scramClientKeyMsg)
+	signature := scramHMAC(scramHash(clientKey), authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+
+	serverFinal, _, err := scram.Next([]byte(
+		withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	expected := scramHMAC(scramHMAC(salted, scramServerKeyMsg), authMessage)
+	if !hmac.Equal(serverFinal, []byte("v="+base64.StdEncoding.EncodeToString(expected))) {
+		t.Errorf("Unexpected server signature %q", serverFinal)
+	}
+	return serverFinal, nil
+}
+
+func TestScramExchange(t *testing.T) {
+	store := NewMemoryPasswordStore(make(map[string][]byte), PasswordStoreOpts{})
+	if err := store.Set("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	scram := NewScramExchange(store)
+	if _, err := scramClient(t, scram, "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	_, done, err := scram.Next(nil)
+	if err != nil || !done {
+		t.Fatalf("Expected exchange to complete, got done=%v err=%v", done, err)
+	}
+	if scram.Account() != "foo" {
+		t.Errorf("Expected account foo, got %q", scram.Account())
+	}
+
+	if _, err := scramClient(t, NewScramExchange(store), "foo", "baz"); err != ErrScramInvalidProof {
+		t.Errorf("Expected ErrScramInvalidProof, got %v", err)
+	}
+}
+
+func TestScramChannelBinding(t *testing.T) {
+	store := NewMemoryPasswordStore(make(map[string][]byte), PasswordStoreOpts{})
+	if err := store.Set("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := NewScramExchange(store).Next([]byte("p=tls-unique,,n=foo,r=abc"))
+	if err != ErrScramChannelBinding {
+		t.Errorf("Expected ErrScramChannelBinding, got %v", err)
+	}
+}
+
+func TestScramUnknownAccount(t *testing.T) {
+	store := NewMemoryPasswordStore(make(map[string][]byte), PasswordStoreOpts{})
+
+	first := func(username string) string {
+		challenge, _, err := NewScramExchange(store).Next([]byte("n,,n=" + username + ",r=abc"))
+		if err != nil {
+			t.Fatalf("Expected unknown account to get a challenge, got %v", err)
+		}
+		attrs, _ := parseScramAttributes(string(challenge))
+		return attrs['s'] + "," + attrs['i']
+	}
+	if first("nobody") != first("Nobody") {
+		t.Error("Expected the same salt for an unknown account each time")
+	}
+	if first("nobody") == first("someone") {
+		t.Error("Expected different salts for different unknown accounts")
+	}
+
+	if _, err := scramClient(t, NewScramExchange(store), "nobody", "bar"); err != ErrScramInvalidProof {
+		t.Errorf("Expected ErrScramInvalidProof, got %v", err)
+	}
+}
+
+func TestScramAbort(t *testing.T) {
+	server := newTestNickServer("")
+	client := newTestNickClient(server, "foo")
+	client.authorized = true
+	client.registered = false
+	authenticate := func(arg string) string {
+		msg := &AuthenticateCommand{arg: arg}
+		msg.SetClient(client)
+		msg.HandleRegServer(server)
+		return <-client.replies
+	}
+
+	authenticate(SCRAM_MECHANISM)
+	if reply := authenticate(base64.StdEncoding.EncodeToString(
+		[]byte("n,,n=alice,r=rOprNGfwEbeRWgbNEkqO"),
+	)); !strings.Contains(reply, "AUTHENTICATE ") {
+		t.Fatalf("Expected the server-first message, got %q", reply)
+	}
+
+	if reply := authenticate("*"); !strings.Contains(reply, " 906 ") {
+		t.Fatalf("Expected the exchange to be aborted, got %q", reply)
+	}
+	if reply := authenticate(SCRAM_MECHANISM); !strings.HasSuffix(reply, " AUTHENTICATE +") {
+		t.Errorf("Expected a new exchange to start after the abort, got %q", reply)
+	}
+	if client.sasl.Scram() == nil || client.sasl.Scram().Account() != "" {
+		t.Error("Expected a fresh SCRAM exchange")
+	}
+}
@@ -11,10 +11,12 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
 )
 
 type ServerCommand interface {
@@ -28,27 +30,48 @@ type RegServerCommand interface {
 }
 
 type Server struct {
-	config      *Config
-	metrics     *Metrics
-	channels    *ChannelNameMap
-	connections *Counter
-	clients     *ClientLookupSet
-	ctime       time.Time
-	idle        chan *Client
-	motdFile    string
-	name        Name
-	network     Name
-	description string
-	newConns    chan net.Conn
-	operators   map[Name][]byte
-	accounts    PasswordStore
-	password    []byte
-	signals     chan os.Signal
-	done        chan bool
-	whoWas      *WhoWasList
-	ids         map[string]*Identity
+	config       *Config
+	configMutex  sync.RWMutex
+	metrics      *Metrics
+	channels     *ChannelNameMap
+	connections  *Counter
+	clients      *ClientLookupSet
+	ctime        time.Time
+	idle         chan *Client
+	motdFile     string
+	name         Name
+	network      Name
+	description  string
+	newConns     chan net.Conn
+	operators    map[Name]*Oper
+	accounts     PasswordStore
+	password     []byte
+	signals      chan os.Signal
+	done         chan bool
+	whoWas       *WhoWasList
+	ids          map[string]*Identity
+	isupport     *ISupportList
+	capabilities CapValues
+	db           *bolt.DB
+	channelStore ChannelStore
+	history      HistoryStore
+	xlines       *XLines
+	connLimits   *ConnLimiter
+	dnsbl        *DNSBL
+	cloaker      *Cloaker
+	vhosts       VHostStore
+	auditMutex   sync.RWMutex
+	audit        *AuditLog
+	services     map[Name]*Service
+	linksMutex   sync.RWMutex
+	links        map[Name]*Link
+	remotes      map[Name]*RemoteServer
 }
 
+const (
+	REJECT_TIMEOUT = 5 * time.Second // how long to spend telling a rejected connection why
+)
+
 var (
 	SERVER_SIGNALS = []os.Signal{
 		syscall.SIGINT, syscall.SIGHUP,
@@ -70,12 +93,16 @@ func NewServer(config *Config) *Server {
 		network:     NewName(config.Network.Name),
 		description: config.Server.Description,
 		newConns:    make(chan net.Conn),
+		connLimits:  NewConnLimiter(&config.Connections),
+		cloaker:     config.NewCloaker(),
 		operators:   config.Operators(),
-		accounts:    NewMemoryPasswordStore(config.Accounts(), PasswordStoreOpts{}),
 		signals:     make(chan os.Signal, len(SERVER_SIGNALS)),
 		done:        make(chan bool),
 		whoWas:      NewWhoWasList(100),
 		ids:         make(map[string]*Identity),
+		services:    make(map[Name]*Service),
+		links:       make(map[Name]*Link),
+		remotes:     make(map[Name]*RemoteServer),
 	}
 
 	log.Debugf("accounts: %v", config.Accounts())
@@ -87,6 +114,71 @@ func NewServer(config *Config) *Server {
 		server.password = config.Server.PasswordBytes()
 	}
 
+	if config.Server.Database != "" {
+		db, err := OpenDatabase(config.Server.Database)
+		if err != nil {
+			log.Fatalf("error opening database %s: %s", config.Server.Database, err)
+		}
+		server.db = db
+		server.channelStore = NewBoltChannelStore(db)
+	} else {
+		server.channelStore = NewMemoryChannelStore()
+	}
+
+	var xlineStore XLineStore = NewMemoryXLineStore()
+	server.vhosts = NewMemoryVHostStore()
+	if server.db != nil {
+		xlineStore = NewBoltXLineStore(server.db)
+		server.vhosts = NewBoltVHostStore(server.db)
+	}
+	xlines, err := NewXLines(xlineStore)
+	if err != nil {
+		log.Fatalf("error loading server bans: %s", err)
+	}
+	server.xlines = xlines
+
+	if server.db != nil && config.Server.AccountStore != AccountStoreMemory {
+		var err error
+		server.accounts, err = NewBoltPasswordStore(
+			server.db, config.Accounts(), PasswordStoreOpts{},
+		)
+		if err != nil {
+			log.Fatalf("error loading accounts: %s", err)
+		}
+	} else {
+		server.accounts = NewMemoryPasswordStore(
+			config.Accounts(), PasswordStoreOpts{},
+		)
+	}
+	server.checkOperAccounts()
+
+	if config.History.Size > 0 {
+		if config.History.Persistent {
+			server.history = NewBoltHistoryStore(server.db, config.History.Size)
+		} else {
+			server.history = NewMemoryHistoryStore(config.History.Size)
+		}
+	}
+
+	server.dnsbl = config.NewDNSBL()
+
+	audit, err := config.NewAuditLog()
+	if err != nil {
+		log.Fatalf("error opening audit log %s: %s", config.Audit.File, err)
+	}
+	server.audit = audit
+
+	server.setISupport()
+	server.setCapabilities()
+
+	services := []*Service{
+		NewChanServ(server),
+		NewNickServ(server),
+	}
+	for _, service := range services {
+		server.services[service.Nick().ToLower()] = service
+	}
+
 	for _, addr := range config.Server.Listen {
 		server.listen(addr)
 	}
@@ -95,6 +187,24 @@ func NewServer(config *Config) *Server {
 		server.listentls(addr, tlsconfig)
 	}
 
+	for addr, wsconfig := range config.Server.WebSocketListen {
+		server.listenws(addr, wsconfig)
+	}
+
+	for _, addr := range config.Server.LinkListen {
+		server.listenLinks(addr, nil)
+	}
+
+	for addr, tlsconfig := range config.Server.TLSLinkListen {
+		server.listenLinks(addr, tlsconfig)
+	}
+
+	for name, link := range config.Link {
+		if link.Connect {
+			go server.autoConnect(NewName(name))
+		}
+	}
+
 	signal.Notify(server.signals, SERVER_SIGNALS...)
 
 	// server uptime counter
@@ -118,6 +228,33 @@ func NewServer(config *Config) *Server {
 		"Number of client messages exchanged",
 	)
 
+	// client commands delayed by flood protection counter (by command)
+	server.metrics.NewCounterVec(
+		"client", "throttled_commands",
+		"Number of client commands delayed by flood protection",
+		[]string{"command"},
+	)
+
+	// clients disconnected for flooding counter
+	server.metrics.NewCounter(
+		"client", "excess_floods",
+		"Number of clients disconnected for flooding",
+	)
+
+	// connections rejected by connection limits counter (by limit)
+	server.metrics.NewCounterVec(
+		"server", "rejected_connections",
+		"Number of connections rejected by connection limits",
+		[]string{"limit"},
+	)
+
+	// clients listed in DNS blocklists counter (by zone and action)
+	server.metrics.NewCounterVec(
+		"client", "dnsbl_listings",
+		"Number of clients listed in DNS blocklists",
+		[]string{"zone", "action"},
+	)
+
 	// server connections gauge
 	server.metrics.NewGaugeFunc(
 		"server", "connections",
@@ -173,9 +310,9 @@ func NewServer(config *Config) *Server {
 func (server *Server) Wallops(message string) {
 	text := NewText(message)
 	server.clients.Range(func(_ Name, client *Client) bool {
-		if client.flags[WallOps] {
+		if client.HasFlag(WallOps) {
 			server.metrics.Counter("client", "messages").Inc()
-			client.replies <- RplNotice(server, client, text)
+			client.Reply(RplNotice(server, client, text))
 		}
 		return true
 	})
@@ -189,7 +326,7 @@ func (server *Server) Global(message string) {
 	text := NewText(message)
 	server.clients.Range(func(_ Name, client *Client) bool {
 		server.metrics.Counter("client", "messages").Inc()
-		client.replies <- RplNotice(server.ids["global"], client, text)
+		client.Reply(RplNotice(server.ids["global"], client, text))
 		return true
 	})
 }
@@ -210,6 +347,9 @@ func (server *Server) Run() {
 	for {
 		select {
 		case <-server.done:
+			if server.db != nil {
+				server.db.Close()
+			}
 			return
 		case <-server.signals:
 			server.Shutdown()
@@ -231,32 +371,82 @@ func (server *Server) Run() {
 func (s *Server) acceptor(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
-		if err != nil {
+		if err == ErrListenerClosed {
+			return
+		} else if err != nil {
 			log.Errorf("%s accept error: %s", s, err)
 			continue
 		}
-		log.Debugf("%s accept: %s", s, conn.RemoteAddr())
 
-		if _, ok := conn.(*tls.Conn); ok {
-			s.metrics.GaugeVec("server", "clients").WithLabelValues("secure").Inc()
-		} else {
-			s.metrics.GaugeVec("server", "clients").WithLabelValues("insecure").Inc()
-		}
+		// the address of a proxied client is only known once the proxy
+		// has sent its header, and a proxied connection may be wrapped
+		// in TLS, so never wait for it here
+		go s.admit(conn)
+	}
+}
+
+// admit passes an accepted connection on to become a client unless it
+// is D-lined or over the connection limits
+func (s *Server) admit(conn net.Conn) {
+	log.Debugf("%s accept: %s", s, conn.RemoteAddr())
 
-		s.connections.Inc()
-		s.newConns <- conn
+	ip := net.ParseIP(IPString(conn.RemoteAddr()).String())
+	if xline := s.xlines.MatchIP(ip); xline != nil {
+		log.Infof("%s rejecting D-lined connection from %s", s, conn.RemoteAddr())
+		s.reject(conn, "Banned: "+xline.Description())
+		return
 	}
+	if err := s.connLimits.Add(ip, time.Now()); err != nil {
+		log.Infof("%s rejecting connection from %s: %s", s, conn.RemoteAddr(), err)
+		s.metrics.CounterVec("server", "rejected_connections").WithLabelValues(connLimitLabels[err]).Inc()
+		s.reject(conn, err.Error())
+		return
+	}
+
+	if IsSecure(conn) {
+		s.metrics.GaugeVec("server", "clients").WithLabelValues("secure").Inc()
+	} else {
+		s.metrics.GaugeVec("server", "clients").WithLabelValues("insecure").Inc()
+	}
+
+	s.connections.Inc()
+	s.newConns <- conn
+}
+
+// reject sends an ERROR to a connection before closing it, without
+// blocking the caller on a slow connection
+func (s *Server) reject(conn net.Conn, message string) {
+	go func() {
+		conn.SetWriteDeadline(time.Now().Add(REJECT_TIMEOUT))
+		conn.Write([]byte(RplError(message) + CRLF))
+		conn.Close()
+	}()
 }
 
 //
 // listen goroutine
 //
 
-func (s *Server) listen(addr string) {
+// newListener listens on addr, accepting PROXY protocol headers from
+// trusted proxies if enabled for addr
+func (s *Server) newListener(addr string) net.Listener {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatal(s, "listen error: ", err)
+		log.Fatalf("error binding to %s: %s", addr, err)
+	}
+
+	proxy := s.Config().Server.Proxy
+	if proxy.Enabled(addr) {
+		trusted, _ := proxy.TrustedNets()
+		log.Infof("%s accepting PROXY protocol on %s from %s", s, addr,
+			strings.Join(proxy.Trusted, ", "))
+		return NewProxyListener(listener, trusted)
 	}
+	return listener
+}
+
+func (s *Server) listen(addr string) {
+	listener := s.newListener(addr)
 
 	log.Infof("%s listening on %s", s, addr)
 
@@ -267,17 +457,23 @@ func (s *Server) listen(addr string) {
 // listen tls goroutine
 //
 
-func (s *Server) listentls(addr string, tlsconfig *TLSConfig) {
+// tlsConfig loads the certificate of tlsconfig
+func (s *Server) tlsConfig(tlsconfig *TLSConfig) *tls.Config {
 	cert, err := tls.LoadX509KeyPair(tlsconfig.Cert, tlsconfig.Key)
 	if err != nil {
 		log.Fatalf("error loading tls cert/key pair: %s", err)
 	}
-	config := tls.Config{Certificates: []tls.Certificate{cert}}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
 	config.Rand = rand.Reader
-	listener, err := tls.Listen("tcp", addr, &config)
-	if err != nil {
-		log.Fatalf("error binding to %s: %s", addr, err)
+	if tlsconfig.ClientCerts {
+		// certificates are not verified, only their fingerprints are used
+		config.ClientAuth = tls.RequestClientCert
 	}
+	return config
+}
+
+func (s *Server) listentls(addr string, tlsconfig *TLSConfig) {
+	listener := tls.NewListener(s.newListener(addr), s.tlsConfig(tlsconfig))
 
 	log.Infof("%s listening on %s (TLS)", s, addr)
 
@@ -294,17 +490,46 @@ func (s *Server) tryRegister(c *Client) {
 		return
 	}
 
+	if xline := s.xlines.MatchClient(c); xline != nil {
+		log.Infof("%s rejecting %s-lined client %s", s, xline.Type, c)
+		c.ErrYoureBannedCreep(xline.Description())
+		c.Quit(NewText(fmt.Sprintf("%s-lined", xline.Type)))
+		return
+	}
+
+	if c.dnsbl != nil && c.dnsbl.Action == DNSBLRequireSASL && c.sasl.Id() == "" {
+		log.Infof("%s rejecting client %s listed in %s without SASL", s, c, c.dnsbl.Zone)
+		c.ErrYoureBannedCreep(c.dnsbl.Reason)
+		c.Quit("SASL required")
+		return
+	}
+
+	reserved := s.IsNickReserved(c, c.nick)
+	if reserved && s.Config().NickReservation.Enforce == NickEnforceReject {
+		c.ErrNickReserved(c.nick)
+		s.clients.Remove(c)
+		c.nick = ""
+		return
+	}
+
 	c.Register()
+	s.relayFrom(nil, RplIntroduce(c))
 	c.RplWelcome()
 	c.RplYourHost()
 	c.RplCreated()
 	c.RplMyInfo()
+	c.RplISupport()
 
 	lusers := LUsersCommand{}
 	lusers.SetClient(c)
 	lusers.HandleServer(s)
 
 	s.MOTD(c)
+	s.AutoOper(c)
+
+	if reserved {
+		c.EnforceNick()
+	}
 }
 
 func (server *Server) MOTD(client *Client) {
@@ -334,17 +559,63 @@ func (server *Server) MOTD(client *Client) {
 	client.RplMOTDEnd()
 }
 
+// Config returns the current config. REHASH replaces it rather than
+// changing it, so a config returned once can be read without locking.
+func (s *Server) Config() *Config {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	return s.config
+}
+
 func (s *Server) Rehash() error {
-	err := s.config.Reload()
+	config, err := s.Config().Reload()
 	if err != nil {
 		return err
 	}
 
-	s.motdFile = s.config.Server.MOTD
-	s.name = NewName(s.config.Server.Name)
-	s.network = NewName(s.config.Network.Name)
-	s.description = s.config.Server.Description
-	s.operators = s.config.Operators()
+	// open the new audit log before changing anything, so a failed
+	// rehash leaves the server as it was
+	audit, err := config.NewAuditLog()
+	if err != nil {
+		return err
+	}
+
+	s.configMutex.Lock()
+	s.config = config
+	s.configMutex.Unlock()
+	s.connLimits.SetConfig(&config.Connections)
+
+	s.motdFile = config.Server.MOTD
+	s.name = NewName(config.Server.Name)
+	s.network = NewName(config.Network.Name)
+	s.description = config.Server.Description
+	s.operators = config.Operators()
+	s.checkOperAccounts()
+	s.reloadOpers()
+	s.dnsbl = config.NewDNSBL()
+
+	s.auditMutex.Lock()
+	audit, s.audit = s.audit, audit
+	s.auditMutex.Unlock()
+	audit.Close()
+
+	capabilities := s.capabilities
+	s.setCapabilities()
+	if added, removed := s.capabilities.Diff(capabilities); len(added)+len(removed) > 0 {
+		s.NotifyCapabilities(added, removed)
+	}
+
+	isupport := s.isupport
+	s.setISupport()
+	if !isupport.Equal(s.isupport) {
+		s.clients.Range(func(_ Name, client *Client) bool {
+			if client.registered {
+				client.RplISupport()
+			}
+			return true
+		})
+	}
 
 	return nil
 }
@@ -408,7 +679,7 @@ func (msg *RFC2812UserCommand) HandleRegServer(server *Server) {
 	flags := msg.Flags()
 	if len(flags) > 0 {
 		for _, mode := range flags {
-			client.flags[mode] = true
+			client.SetFlag(mode, true)
 		}
 		client.RplUModeIs(client)
 	}
@@ -424,16 +695,22 @@ func (msg *AuthenticateCommand) HandleRegServer(server *Server) {
 	}
 
 	if msg.arg == "*" {
+		client.sasl.Reset()
 		client.ErrSaslAborted()
 		return
 	}
 
 	if !client.sasl.Started() {
-		if msg.arg == "PLAIN" {
-			client.sasl.Start()
+		switch msg.arg {
+		case "PLAIN", "EXTERNAL":
+			client.sasl.Start(msg.arg)
 			client.Reply(RplAuthenticate(client, "+"))
-		} else {
-			client.RplSaslMechs("PLAIN")
+		case SCRAM_MECHANISM:
+			client.sasl.Start(msg.arg)
+			client.sasl.SetScram(NewScramExchange(server.accounts))
+			client.Reply(RplAuthenticate(client, "+"))
+		default:
+			client.RplSaslMechs(SaslMechanisms...)
 			client.ErrSaslFail("Unknown authentication mechanism")
 		}
 		return
@@ -462,6 +739,17 @@ func (msg *AuthenticateCommand) HandleRegServer(server *Server) {
 
 	// Do authentication
 
+	switch client.sasl.Mech() {
+	case "EXTERNAL":
+		server.authExternal(client, data)
+	case SCRAM_MECHANISM:
+		server.authScram(client, data)
+	default:
+		server.authPlain(client, data)
+	}
+}
+
+func (server *Server) authPlain(client *Client, data []byte) {
 	var (
 		authcid  string
 		authzid  string
@@ -478,32 +766,72 @@ func (msg *AuthenticateCommand) HandleRegServer(server *Server) {
 			authzid = authcid
 		} else if authzid != authcid {
 			client.ErrSaslFail("authzid and authcid should be the same")
+			client.sasl.Reset()
 			return
 		}
 	} else {
 		client.ErrSaslFail("invalid authentication blob")
+		client.sasl.Reset()
 		return
 	}
 
-	err = server.accounts.Verify(authcid, password)
+	err := server.VerifyAccount(authcid, password)
 	if err != nil {
 		client.ErrSaslFail("invalid authentication")
+		client.sasl.Reset()
 		return
 	}
 
-	client.sasl.Login(authcid)
-	client.RplLoggedIn(authcid)
+	client.Login(authcid)
 	client.RplSaslSuccess()
+}
 
-	client.flags[Registered] = true
-	client.Reply(
-		RplModeChanges(
-			client, client,
-			ModeChanges{
-				&ModeChange{mode: Registered, op: Add},
-			},
-		),
-	)
+// authScram performs the next step of a SCRAM-SHA-256 exchange
+func (server *Server) authScram(client *Client, data []byte) {
+	scram := client.sasl.Scram()
+	challenge, done, err := scram.Next(data)
+	if err != nil {
+		log.Debugf("%s: SCRAM authentication failed: %s", client, err)
+		client.ErrSaslFail("invalid authentication")
+		client.sasl.Reset()
+		return
+	}
+
+	if done {
+		client.Login(scram.Account())
+		client.RplSaslSuccess()
+		return
+	}
+
+	client.sasl.ResetBuffer()
+	client.Reply(RplAuthenticate(client, base64.StdEncoding.EncodeToString(challenge)))
+}
+
+// authExternal logs the client into the account its TLS client
+// certificate fingerprint is registered to.
+func (server *Server) authExternal(client *Client, data []byte) {
+	if client.certfp == "" {
+		client.ErrSaslFail("no client certificate")
+		client.sasl.Reset()
+		return
+	}
+
+	account, ok := server.accounts.CertFPAccount(client.certfp)
+	if !ok {
+		client.ErrSaslFail("certificate fingerprint not registered")
+		client.sasl.Reset()
+		return
+	}
+
+	authzid := string(data)
+	if authzid != "" && CanonicalAccountName(authzid) != account {
+		client.ErrSaslFail("authzid does not match certificate")
+		client.sasl.Reset()
+		return
+	}
+
+	client.Login(account)
+	client.RplSaslSuccess()
 }
 
 func (msg *UserCommand) setUserInfo(server *Server) {
@@ -609,7 +937,14 @@ func (msg *PrivMsgCommand) HandleServer(server *Server) {
 			return
 		}
 
-		channel.PrivMsg(client, msg.message)
+		channel.PrivMsg(client, msg.message, msg.Tags())
+		return
+	}
+
+	if service := server.services[msg.target.ToLower()]; service != nil {
+		service.Dispatch(client, spacesExpr.Split(
+			strings.TrimSpace(msg.message.String()), -1,
+		))
 		return
 	}
 
@@ -623,12 +958,45 @@ func (msg *PrivMsgCommand) HandleServer(server *Server) {
 		return
 	}
 	server.metrics.Counter("client", "messages").Inc()
-	target.Reply(RplPrivMsg(client, target, msg.message))
-	if target.flags[Away] {
+	item := NewHistoryItem(client, PRIVMSG, target.nick, msg.message, msg.Tags())
+	target.Message(WithTags(RplPrivMsg(client, target, msg.message), item.MessageTags()))
+	server.AddPrivateHistory(client, target, item)
+	if target.HasFlag(Away) {
 		client.RplAway(target)
 	}
 }
 
+func (msg *TagMsgCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	tags := msg.Tags().ClientOnly()
+	if msg.target.IsChannel() {
+		channel := server.channels.Get(msg.target)
+		if channel == nil {
+			client.ErrNoSuchChannel(msg.target)
+			return
+		}
+
+		channel.TagMsg(client, tags)
+		return
+	}
+
+	target := server.clients.Get(msg.target)
+	if target == nil {
+		client.ErrNoSuchNick(msg.target)
+		return
+	}
+	if !client.CanSpeak(target) {
+		client.ErrCannotSendToUser(target.nick, "secure connection required")
+		return
+	}
+	// remote clients have no capabilities here, their server filters
+	// the message for them
+	if len(tags) == 0 || (target.link == nil && !target.HasCapability(MessageTags)) {
+		return
+	}
+	target.Message(RplTagMsg(client, target, tags))
+}
+
 func (client *Client) WhoisChannelsNames(target *Client) []string {
 	chstrs := make([]string, client.channels.Count())
 	index := 0
@@ -673,7 +1041,7 @@ func (m *WhoisCommand) HandleServer(server *Server) {
 
 func whoChannel(client *Client, channel *Channel, friends *ClientSet) {
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
-		if !client.flags[Invisible] || friends.Has(client) {
+		if !client.HasFlag(Invisible) || friends.Has(client) {
 			client.RplWhoReply(channel, member)
 		}
 		return true
@@ -710,28 +1078,27 @@ func (msg *WhoCommand) HandleServer(server *Server) {
 func (msg *OperCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
-	if (msg.hash == nil) || (msg.err != nil) {
+	oper := server.operators[msg.name]
+	if oper == nil || msg.err != nil {
+		log.Warnf("%s: failed OPER as %s: bad password", client, msg.name)
+		server.Audit(NewAuditEntry(AuditOperFailed, client, msg.name.String(), "bad password"))
 		client.ErrPasswdMismatch()
 		return
 	}
+	if !oper.Matches(client) {
+		log.Warnf("%s: failed OPER as %s: account, certificate or host mismatch", client, msg.name)
+		server.Audit(NewAuditEntry(AuditOperFailed, client, msg.name.String(), "account, certificate or host mismatch"))
+		client.ErrNoOperHost()
+		return
+	}
 
-	client.flags[Operator] = true
-	client.flags[WallOps] = true
-	client.RplYoureOper()
-	client.Reply(
-		RplModeChanges(
-			client, client,
-			ModeChanges{
-				&ModeChange{mode: Operator, op: Add},
-				&ModeChange{mode: WallOps, op: Add},
-			},
-		),
-	)
+	client.BecomeOper(oper)
+	server.Audit(NewAuditEntry(AuditOper, client, oper.Name.String(), ""))
 }
 
 func (msg *RehashCommand) HandleServer(server *Server) {
 	client := msg.Client()
-	if !client.flags[Operator] {
+	if !client.HasPrivilege(PrivRehash) {
 		client.ErrNoPrivileges()
 		return
 	}
@@ -743,12 +1110,14 @@ func (msg *RehashCommand) HandleServer(server *Server) {
 
 	err := server.Rehash()
 	if err != nil {
+		server.Audit(NewAuditEntry(AuditRehash, client, "", err.Error()))
 		server.Wallopsf(
 			"ERROR: Rehashing config failed (%s)",
 			err,
 		)
 		return
 	}
+	server.Audit(NewAuditEntry(AuditRehash, client, "", ""))
 
 	client.RplRehashing()
 }
@@ -756,9 +1125,9 @@ func (msg *RehashCommand) HandleServer(server *Server) {
 func (msg *AwayCommand) HandleServer(server *Server) {
 	client := msg.Client()
 	if len(msg.text) > 0 {
-		client.flags[Away] = true
+		client.SetFlag(Away, true)
 	} else {
-		delete(client.flags, Away)
+		client.SetFlag(Away, false)
 	}
 	client.awayMessage = msg.text
 }
@@ -783,7 +1152,7 @@ func (msg *MOTDCommand) HandleServer(server *Server) {
 func (msg *NoticeCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
-	if msg.target == "*" && client.flags[Operator] {
+	if msg.target == "*" && client.HasPrivilege(PrivGlobalNotice) {
 		server.Global(msg.message.String())
 		return
 	}
@@ -795,7 +1164,7 @@ func (msg *NoticeCommand) HandleServer(server *Server) {
 			return
 		}
 
-		channel.Notice(client, msg.message)
+		channel.Notice(client, msg.message, msg.Tags())
 		return
 	}
 
@@ -810,7 +1179,9 @@ func (msg *NoticeCommand) HandleServer(server *Server) {
 		return
 	}
 	server.metrics.Counter("client", "messages").Inc()
-	target.Reply(RplNotice(client, target, msg.message))
+	item := NewHistoryItem(client, NOTICE, target.nick, msg.message, msg.Tags())
+	target.Message(WithTags(RplNotice(client, target, msg.message), item.MessageTags()))
+	server.AddPrivateHistory(client, target, item)
 }
 
 func (msg *KickCommand) HandleServer(server *Server) {
@@ -932,7 +1303,7 @@ func (msg *LUsersCommand) HandleServer(server *Server) {
 
 func (msg *WallopsCommand) HandleServer(server *Server) {
 	client := msg.Client()
-	if !client.flags[Operator] {
+	if !client.HasPrivilege(PrivWallops) {
 		client.ErrNoPrivileges()
 		return
 	}
@@ -942,7 +1313,7 @@ func (msg *WallopsCommand) HandleServer(server *Server) {
 
 func (msg *KillCommand) HandleServer(server *Server) {
 	client := msg.Client()
-	if !client.flags[Operator] {
+	if !client.HasPrivilege(PrivKill) && !client.HasPrivilege(PrivLocalKill) {
 		client.ErrNoPrivileges()
 		return
 	}
@@ -953,6 +1324,17 @@ func (msg *KillCommand) HandleServer(server *Server) {
 		return
 	}
 
+	if target.link != nil && !client.HasPrivilege(PrivKill) {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	if target.link != nil {
+		target.link.Send(RplLinkKill(client.Nick(), target, msg.comment.String()))
+	}
+
+	server.Audit(NewAuditEntry(AuditKill, client, target.UserHost(false).String(), msg.comment.String()))
+
 	quitMsg := fmt.Sprintf("KILLed by %s: %s", client.Nick(), msg.comment)
 	target.Quit(NewText(quitMsg))
 }
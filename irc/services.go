@@ -0,0 +1,103 @@
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ServiceHandler handles a command sent to a service by a client
+type ServiceHandler func(service *Service, client *Client, args []string)
+
+type ServiceCommand struct {
+	usage   string
+	help    string
+	handler ServiceHandler
+}
+
+// Service is a pseudo-client built into the server (e.g. ChanServ) that
+// clients talk to with its own command (e.g. CHANSERV) or via PRIVMSG.
+type Service struct {
+	*Identity
+	server   *Server
+	commands map[string]*ServiceCommand
+}
+
+func NewService(server *Server, nickname string) *Service {
+	return &Service{
+		Identity: NewIdentity(server.name.String(), nickname),
+		server:   server,
+		commands: make(map[string]*ServiceCommand),
+	}
+}
+
+// AddCommand registers a service command, name must be uppercase
+func (service *Service) AddCommand(name, usage, help string, handler ServiceHandler) {
+	service.commands[name] = &ServiceCommand{
+		usage:   usage,
+		help:    help,
+		handler: handler,
+	}
+}
+
+func (service *Service) Notice(client *Client, format string, args ...interface{}) {
+	client.Reply(RplNotice(service, client, NewText(fmt.Sprintf(format, args...))))
+}
+
+// Dispatch runs the service command given by the first argument
+func (service *Service) Dispatch(client *Client, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		service.Help(client)
+		return
+	}
+
+	name := strings.ToUpper(args[0])
+	if name == "HELP" {
+		service.Help(client)
+		return
+	}
+
+	command, ok := service.commands[name]
+	if !ok {
+		service.Notice(client, "Unknown command %s. Try HELP", name)
+		return
+	}
+	command.handler(service, client, args[1:])
+}
+
+// Usage tells the client how to use the named command
+func (service *Service) Usage(client *Client, name string) {
+	service.Notice(client, "Usage: %s %s", name, service.commands[name].usage)
+}
+
+func (service *Service) Help(client *Client) {
+	names := make([]string, 0, len(service.commands))
+	for name := range service.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	service.Notice(client, "%s commands:", service.Nick())
+	for _, name := range names {
+		command := service.commands[name]
+		service.Notice(client, "%s %s - %s", name, command.usage, command.help)
+	}
+}
+
+// ServiceMsgCommand is a command addressed to a service
+// <service> <command> [args...]
+type ServiceMsgCommand struct {
+	BaseCommand
+	service Name
+	args    []string
+}
+
+func (msg *ServiceMsgCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	service := server.services[msg.service]
+	if service == nil {
+		client.ErrUnknownCommand(msg.Code())
+		return
+	}
+	service.Dispatch(client, msg.args)
+}
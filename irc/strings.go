@@ -1,16 +1,35 @@
 package irc
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
 	"golang.org/x/text/unicode/norm"
 )
 
+const (
+	// ChannelTypes are the prefixes a channel name may begin with
+	ChannelTypes = "&!#+"
+
+	// MaxChannelLength is the maximum length of a channel name
+	// including its prefix
+	MaxChannelLength = 64
+
+	// MaxNicknameLength is the maximum length of a nickname
+	MaxNicknameLength = 32
+)
+
 var (
 	// regexps
-	ChannelNameExpr = regexp.MustCompile(`^[&!#+][\pL\pN]{1,63}$`)
-	NicknameExpr    = regexp.MustCompile(`^[\pL\pN\pP\pS]{1,32}$`)
+	ChannelNameExpr = regexp.MustCompile(fmt.Sprintf(
+		`^[%s][\pL\pN]{1,%d}$`,
+		regexp.QuoteMeta(ChannelTypes), MaxChannelLength-1,
+	))
+	NicknameExpr = regexp.MustCompile(fmt.Sprintf(
+		`^[\pL\pN\pP\pS]{1,%d}$`,
+		MaxNicknameLength,
+	))
 )
 
 // Names are normalized and canonicalized to remove formatting marks
@@ -96,3 +115,27 @@ var ctcpEscaper = strings.NewReplacer("\x00", "\x200", "\n", "\x20n", "\r", "\x2
 func NewCTCPText(str string) CTCPText {
 	return CTCPText(ctcpEscaper.Replace(str))
 }
+
+// splitTokens splits space separated tokens into lines of at most
+// maxTokens tokens each (0 for no limit), where every line fits within
+// MAX_REPLY_LEN given the length of the rest of the reply.
+func splitTokens(tokens []string, baseLen, maxTokens int) [][]string {
+	lines := make([][]string, 0)
+	line := make([]string, 0)
+	lineLen := baseLen
+	for _, token := range tokens {
+		tooLong := (lineLen + len(token) + 1) > MAX_REPLY_LEN
+		tooMany := maxTokens > 0 && len(line) == maxTokens
+		if len(line) > 0 && (tooLong || tooMany) {
+			lines = append(lines, line)
+			line = make([]string, 0)
+			lineLen = baseLen
+		}
+		line = append(line, token)
+		lineLen += len(token) + 1 // " " after each token
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,131 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// MAX_TAGS_LEN is the maximum length of the tags a client may send
+	// excluding the leading '@' and trailing ' '
+	MAX_TAGS_LEN = 4094
+)
+
+var (
+	tagValueEscaper = strings.NewReplacer(
+		"\\", "\\\\", ";", "\\:", " ", "\\s", "\r", "\\r", "\n", "\\n",
+	)
+)
+
+// Tags are IRCv3 message tags (https://ircv3.net/specs/extensions/message-tags)
+// mapping tag keys to their unescaped values.
+type Tags map[string]string
+
+// ParseTags parses the raw tags of a message without the leading '@'
+func ParseTags(raw string) Tags {
+	tags := make(Tags)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		parts := strings.SplitN(tag, "=", 2)
+		if parts[0] == "" {
+			continue
+		}
+		if len(parts) > 1 {
+			tags[parts[0]] = UnescapeTagValue(parts[1])
+		} else {
+			tags[parts[0]] = ""
+		}
+	}
+	return tags
+}
+
+// EscapeTagValue escapes a tag value for sending on the wire
+func EscapeTagValue(value string) string {
+	return tagValueEscaper.Replace(value)
+}
+
+// UnescapeTagValue unescapes a tag value received on the wire
+func UnescapeTagValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i == len(value) {
+			// a trailing lone '\' is dropped
+			break
+		}
+		switch value[i] {
+		case ':':
+			buf.WriteByte(';')
+		case 's':
+			buf.WriteByte(' ')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'n':
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+	return buf.String()
+}
+
+// ClientOnly returns the client-only tags (prefixed with '+')
+func (tags Tags) ClientOnly() Tags {
+	clientOnly := make(Tags)
+	for key, value := range tags {
+		if strings.HasPrefix(key, "+") {
+			clientOnly[key] = value
+		}
+	}
+	return clientOnly
+}
+
+// String returns the tags escaped and sorted by key without the leading '@'
+func (tags Tags) String() string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	strs := make([]string, len(keys))
+	for index, key := range keys {
+		if tags[key] == "" {
+			strs[index] = key
+		} else {
+			strs[index] = key + "=" + EscapeTagValue(tags[key])
+		}
+	}
+	return strings.Join(strs, ";")
+}
+
+// SplitTags splits a line into its tags and the rest of the line
+func SplitTags(line string) (Tags, string) {
+	if !strings.HasPrefix(line, "@") {
+		return make(Tags), line
+	}
+	raw, rest := splitArg(line[len("@"):])
+	return ParseTags(raw), rest
+}
+
+// WithTags returns reply with tags merged into any tags it already has
+func WithTags(reply string, tags Tags) string {
+	if len(tags) == 0 {
+		return reply
+	}
+	existing, rest := SplitTags(reply)
+	for key, value := range tags {
+		existing[key] = value
+	}
+	return "@" + existing.String() + " " + rest
+}
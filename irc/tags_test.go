@@ -0,0 +1,98 @@
+package irc
+
+import (
+	"testing"
+)
+
+func TestParseLineTags(t *testing.T) {
+	tags, command, args := ParseLine(
+		`@+example=raw+:value;aaa=bbb;+foo\sbar=a\:b\\c\s;ccc :nick!user@host PRIVMSG #chan :Hello World!`,
+	)
+
+	if command != PRIVMSG {
+		t.Errorf("Expected command %s, got %s", PRIVMSG, command)
+	}
+	if len(args) != 2 || args[0] != "#chan" || args[1] != "Hello World!" {
+		t.Errorf("Unexpected args: %q", args)
+	}
+
+	expected := Tags{
+		"+example":   "raw+:value",
+		"aaa":        "bbb",
+		"+foo\\sbar": "a;b\\c ",
+		"ccc":        "",
+	}
+	if len(tags) != len(expected) {
+		t.Errorf("Expected %d tags, got %d: %v", len(expected), len(tags), tags)
+	}
+	for key, value := range expected {
+		if actual, ok := tags[key]; !ok || actual != value {
+			t.Errorf("Expected tag %q=%q, got %q", key, value, actual)
+		}
+	}
+
+	clientOnly := tags.ClientOnly()
+	if len(clientOnly) != 2 {
+		t.Errorf("Expected 2 client-only tags, got %v", clientOnly)
+	}
+}
+
+func TestParseLineNoTags(t *testing.T) {
+	tags, command, args := ParseLine("NICK foo")
+	if len(tags) != 0 {
+		t.Errorf("Expected no tags, got %v", tags)
+	}
+	if command != NICK || len(args) != 1 || args[0] != "foo" {
+		t.Errorf("Unexpected command %s %q", command, args)
+	}
+}
+
+func TestTagValueEscaping(t *testing.T) {
+	values := []string{
+		"", "plain", "semi;colon", "back\\slash", "sp ace", "cr\rlf\n",
+	}
+	for _, value := range values {
+		if actual := UnescapeTagValue(EscapeTagValue(value)); actual != value {
+			t.Errorf("Expected %q to round trip, got %q", value, actual)
+		}
+	}
+
+	if actual := UnescapeTagValue(`trailing\`); actual != "trailing" {
+		t.Errorf("Expected trailing backslash to be dropped, got %q", actual)
+	}
+	if actual := UnescapeTagValue(`\b\a`); actual != "ba" {
+		t.Errorf("Expected unknown escapes to be dropped, got %q", actual)
+	}
+}
+
+func TestWithTags(t *testing.T) {
+	reply := WithTags("@aaa=bbb :nick PRIVMSG #chan :hi", Tags{"+ccc": "d e"})
+	expected := `@+ccc=d\se;aaa=bbb :nick PRIVMSG #chan :hi`
+	if reply != expected {
+		t.Errorf("Expected %q, got %q", expected, reply)
+	}
+
+	if reply := WithTags(":nick PRIVMSG #chan :hi", nil); reply != ":nick PRIVMSG #chan :hi" {
+		t.Errorf("Expected reply without tags to be unchanged, got %q", reply)
+	}
+}
+
+func TestClientFilterTags(t *testing.T) {
+	reply := "@+foo=bar;time=2017-01-01T00:00:00.000Z :nick PRIVMSG #chan :hi"
+
+	client := &Client{capabilities: make(CapabilitySet)}
+	if actual := client.filterTags(reply); actual != ":nick PRIVMSG #chan :hi" {
+		t.Errorf("Expected all tags to be stripped, got %q", actual)
+	}
+
+	client.capabilities[ServerTime] = true
+	expected := "@time=2017-01-01T00:00:00.000Z :nick PRIVMSG #chan :hi"
+	if actual := client.filterTags(reply); actual != expected {
+		t.Errorf("Expected only the time tag to be kept, got %q", actual)
+	}
+
+	client.capabilities[MessageTags] = true
+	if actual := client.filterTags(reply); actual != reply {
+		t.Errorf("Expected all tags to be kept, got %q", actual)
+	}
+}
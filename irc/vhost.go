@@ -0,0 +1,252 @@
+package irc
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// VHostStore keeps the vhosts operators assign to accounts
+type VHostStore interface {
+	Get(account string) (Name, error)
+	Set(account string, vhost Name) error
+	Delete(account string) error
+}
+
+type MemoryVHostStore struct {
+	sync.RWMutex
+	vhosts map[string]Name
+}
+
+func NewMemoryVHostStore() *MemoryVHostStore {
+	return &MemoryVHostStore{vhosts: make(map[string]Name)}
+}
+
+func (store *MemoryVHostStore) Get(account string) (Name, error) {
+	store.RLock()
+	defer store.RUnlock()
+
+	return store.vhosts[account], nil
+}
+
+func (store *MemoryVHostStore) Set(account string, vhost Name) error {
+	store.Lock()
+	defer store.Unlock()
+
+	store.vhosts[account] = vhost
+	return nil
+}
+
+func (store *MemoryVHostStore) Delete(account string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	delete(store.vhosts, account)
+	return nil
+}
+
+// BoltVHostStore keeps vhosts in the "vhosts" bucket of a BoltDB
+// database.
+type BoltVHostStore struct {
+	db *bolt.DB
+}
+
+func NewBoltVHostStore(db *bolt.DB) *BoltVHostStore {
+	return &BoltVHostStore{db: db}
+}
+
+func (store *BoltVHostStore) Get(account string) (vhost Name, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		vhost = Name(tx.Bucket([]byte("vhosts")).Get([]byte(account)))
+		return nil
+	})
+	return
+}
+
+func (store *BoltVHostStore) Set(account string, vhost Name) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("vhosts")).Put([]byte(account), []byte(vhost))
+	})
+}
+
+func (store *BoltVHostStore) Delete(account string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("vhosts")).Delete([]byte(account))
+	})
+}
+
+// VHost returns the vhost of an account if any, set by an operator or
+// in the config
+func (server *Server) VHost(account string) Name {
+	account = CanonicalAccountName(account)
+	vhost, err := server.vhosts.Get(account)
+	if err != nil {
+		log.Errorf("error loading vhost of %s: %s", account, err)
+	}
+	if vhost != "" {
+		return vhost
+	}
+	return NewName(server.Config().Cloaking.VHosts[account])
+}
+
+// SetVHost shows the client with vhost, or its cloak if vhost is empty
+func (client *Client) SetVHost(vhost Name) {
+	client.vhost = vhost
+	if vhost == "" {
+		vhost = client.cloak
+	}
+	if vhost != client.hostmask {
+		client.ChangeHost(client.username, vhost)
+	}
+}
+
+// ChangeHost changes the visible username and host of the client,
+// telling clients with the chghost capability and making the client
+// rejoin its channels for the others.
+func (client *Client) ChangeHost(username Name, hostmask Name) {
+	if !client.registered {
+		client.username = username
+		client.hostmask = hostmask
+		return
+	}
+
+	// make replies before changing host to capture the original source
+	reply := NewStringReply(client, CHGHOST, "%s %s", username, hostmask)
+	quit := RplQuit(client, "Changing host")
+	client.username = username
+	client.hostmask = hostmask
+
+	client.Friends().Range(func(friend *Client) bool {
+		if friend.HasCapability(ChgHost) {
+			friend.Reply(reply)
+		} else if friend != client {
+			friend.Reply(quit)
+			client.rejoinFor(friend)
+		}
+		return true
+	})
+	client.RplHostHidden()
+	client.server.Relay(client, reply)
+}
+
+// rejoinFor shows the client joining the channels it shares with friend
+// with its channel modes
+func (client *Client) rejoinFor(friend *Client) {
+	client.channels.Range(func(channel *Channel) bool {
+		if !channel.members.Has(friend) {
+			return true
+		}
+		friend.Reply(RplJoin(client, channel))
+
+		changes := ChannelModeChanges{}
+		for _, mode := range []ChannelMode{ChannelOperator, Voice} {
+			if channel.members.HasMode(client, mode) {
+				changes = append(changes, &ChannelModeChange{
+					mode: mode, op: Add, arg: client.Nick().String(),
+				})
+			}
+		}
+		if len(changes) > 0 {
+			friend.Reply(NewStringReply(client.server, MODE, "%s %s", channel, changes))
+		}
+		return true
+	})
+}
+
+//
+// commands
+//
+
+// CHGHOST <nick> [<host>]
+func (msg *ChgHostCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.HasPrivilege(PrivVHost) {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	target := server.clients.Get(msg.nickname)
+	if target == nil {
+		client.ErrNoSuchNick(msg.nickname)
+		return
+	}
+	if target.link != nil {
+		client.Reply(RplNotice(server, client, NewText(
+			fmt.Sprintf("%s is not on this server", target.Nick()))))
+		return
+	}
+
+	vhost := msg.vhost
+	if vhost == "" && target.sasl.Id() != "" {
+		vhost = server.VHost(target.sasl.Id())
+	} else if vhost != "" && !IsHostname(vhost.String()) {
+		client.Reply(RplNotice(server, client, NewText(
+			fmt.Sprintf("Invalid vhost %s", vhost))))
+		return
+	}
+
+	target.SetVHost(vhost)
+	server.Wallopsf("%s changed the host of %s to %s", client.Nick(), target.Nick(), target.hostmask)
+	server.Audit(NewAuditEntry(AuditChgHost, client, target.UserHost(false).String(), target.hostmask.String()))
+}
+
+// VHOST SET <account> <host>
+// VHOST DEL <account>
+func (msg *VHostCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.HasPrivilege(PrivVHost) {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	notice := func(format string, args ...interface{}) {
+		client.Reply(RplNotice(server, client, NewText(fmt.Sprintf(format, args...))))
+	}
+
+	account := CanonicalAccountName(msg.account)
+	if _, ok := server.accounts.Get(account); !ok {
+		notice("No such account %s", msg.account)
+		return
+	}
+
+	var err error
+	switch msg.subCommand {
+	case "SET":
+		if !IsHostname(msg.vhost.String()) {
+			notice("Invalid vhost %s", msg.vhost)
+			return
+		}
+		err = server.vhosts.Set(account, msg.vhost)
+	case "DEL":
+		err = server.vhosts.Delete(account)
+	default:
+		notice("Unknown VHOST command %s, use SET or DEL", msg.subCommand)
+		return
+	}
+	if err != nil {
+		log.Errorf("error changing vhost of %s: %s", account, err)
+		notice("Error changing the vhost of %s", msg.account)
+		return
+	}
+
+	targets := make([]*Client, 0)
+	server.clients.Range(func(_ Name, target *Client) bool {
+		if target.link == nil && CanonicalAccountName(target.sasl.Id()) == account {
+			targets = append(targets, target)
+		}
+		return true
+	})
+	vhost := server.VHost(account)
+	for _, target := range targets {
+		target.SetVHost(vhost)
+	}
+
+	if vhost == "" {
+		server.Wallopsf("%s removed the vhost of account %s", client.Nick(), account)
+	} else {
+		server.Wallopsf("%s set the vhost of account %s to %s", client.Nick(), account, vhost)
+	}
+	server.Audit(NewAuditEntry(AuditVHost, client, account, vhost.String()))
+}
@@ -0,0 +1,65 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChangeHost(t *testing.T) {
+	server, link := newTestLink(t)
+	server.channelStore = NewMemoryChannelStore()
+	link.handle(":b.test NICK foo 0 user host.test mask.test * + :Foo")
+	foo := server.clients.Get("foo")
+
+	channel := NewChannel(server, "#test", false)
+	members := map[*Client]bool{foo: false}
+	for _, capable := range []bool{true, false} {
+		client := &Client{
+			capabilities: CapabilitySet{ChgHost: capable},
+			channels:     NewChannelSet(),
+			flags:        make(map[UserMode]bool),
+			nick:         NewName(map[bool]string{true: "capable", false: "incapable"}[capable]),
+			registered:   true,
+			replies:      make(chan string, 10),
+			server:       server,
+		}
+		members[client] = capable
+	}
+	for client := range members {
+		client.channels.Add(channel)
+		channel.members.Add(client)
+	}
+	channel.members.Get(foo).Set(Voice)
+
+	link.handle(":foo CHGHOST user vhost.test")
+	if foo.hostmask != "vhost.test" {
+		t.Errorf("Expected foo to be shown as vhost.test, got %s", foo.hostmask)
+	}
+
+	for client, capable := range members {
+		if client == foo {
+			continue
+		}
+		lines := []string{}
+		for len(client.replies) > 0 {
+			lines = append(lines, <-client.replies)
+		}
+
+		expected := []string{":foo!user@mask.test CHGHOST user vhost.test"}
+		if !capable {
+			expected = []string{
+				":foo!user@mask.test QUIT :Changing host",
+				":foo!user@vhost.test JOIN #test",
+				":a.test MODE #test +v foo",
+			}
+		}
+		if len(lines) != len(expected) {
+			t.Fatalf("Expected %d lines for %s, got %q", len(expected), client.nick, lines)
+		}
+		for i := range expected {
+			if !strings.HasSuffix(strings.TrimRight(lines[i], CRLF), expected[i]) {
+				t.Errorf("Expected %q for %s, got %q", expected[i], client.nick, lines[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package irc
+
+import (
+	"net"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WebIRCGateway returns the name and config of the gateway block
+// allowing connections from addr
+func (server *Server) WebIRCGateway(addr net.Addr) (string, *WebIRCConfig) {
+	for name, gateway := range server.Config().WebIRC {
+		hosts, err := ParseNetworks(gateway.Hosts)
+		if err != nil {
+			continue
+		}
+		if IsTrustedAddr(addr, hosts) {
+			return name, gateway
+		}
+	}
+	return "", nil
+}
+
+// WEBIRC <password> <gateway> <hostname> <ip> [:<options>]
+func (msg *WebIRCCommand) HandleRegServer(server *Server) {
+	client := msg.Client()
+	addr := client.socket.conn.RemoteAddr()
+
+	name, gateway := server.WebIRCGateway(addr)
+	if gateway == nil {
+		log.Warnf("%s: WEBIRC from untrusted address %s", client, addr)
+		client.Quit("WEBIRC from untrusted address")
+		return
+	}
+
+	hash, err := DecodePassword(gateway.Password)
+	if err != nil || ComparePassword(hash, []byte(msg.password)) != nil {
+		log.Warnf("%s: WEBIRC with bad password for gateway %s", client, name)
+		client.ErrPasswdMismatch()
+		client.Quit("bad password")
+		return
+	}
+
+	if client.HasNick() || client.HasUsername() {
+		client.Quit("WEBIRC must be sent before registration")
+		return
+	}
+
+	ip := net.ParseIP(msg.ip)
+	if ip == nil {
+		client.Quit("WEBIRC with invalid IP address")
+		return
+	}
+
+	hostname := NewName(msg.hostname)
+	if !IsHostname(msg.hostname) || msg.hostname == ip.String() {
+		hostname = LookupHostname(NewName(ip.String()))
+	}
+
+	log.Infof("%s: WEBIRC from gateway %s (%s) for %s (%s)",
+		client, name, msg.gateway, hostname, ip)
+
+	client.SetHostname(hostname)
+	client.ip = ip
+	if !client.applyDNSBL(server.dnsbl.Lookup(ip)) {
+		return
+	}
+	// the gateway's certificate is not the user's
+	client.certfp = ""
+	if msg.options["secure"] {
+		client.SetFlag(SecureConn, true)
+	} else {
+		client.SetFlag(SecureConn, false)
+	}
+}
@@ -0,0 +1,61 @@
+package irc
+
+import (
+	"net"
+	"testing"
+)
+
+// addrConn is a connection from a fixed remote address
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (conn *addrConn) RemoteAddr() net.Addr {
+	return conn.addr
+}
+
+func TestWebIRC(t *testing.T) {
+	config := &Config{}
+	config.WebIRC = map[string]*WebIRCConfig{
+		"gateway": {
+			// password 'admin'
+			PassConfig: PassConfig{"JDJhJDA0JGtUU1JVc1JOUy9DbEh1WEdvYVlMdGVnclp6YnA3NDBOZGY1WUZhdTZtRzVmb1VKdXQ5ckZD"},
+			Hosts:      []string{"192.0.2.0/24"},
+		},
+	}
+	server := &Server{config: config, cloaker: NewCloaker("secret", 2, "IP")}
+
+	untrusted := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1234}
+	if name, _ := server.WebIRCGateway(untrusted); name != "" {
+		t.Errorf("Expected no gateway for %s, got %s", untrusted, name)
+	}
+
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	client := &Client{
+		flags:  make(map[UserMode]bool),
+		server: server,
+		socket: NewSocket(&addrConn{conn, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}}),
+	}
+	client.SetHostname("gateway.example.com")
+
+	command, err := ParseWebIRCCommand([]string{
+		"admin", "webchat", "user.example.com", "203.0.113.1", "secure",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	command.SetClient(client)
+	command.(RegServerCommand).HandleRegServer(server)
+
+	if client.hostname != "user.example.com" {
+		t.Errorf("Expected hostname user.example.com, got %s", client.hostname)
+	}
+	if client.hostmask != server.cloaker.Cloak("user.example.com") {
+		t.Errorf("Expected the cloak to be updated, got %s", client.hostmask)
+	}
+	if !client.HasFlag(SecureConn) {
+		t.Error("Expected the client to be secure")
+	}
+}
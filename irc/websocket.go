@@ -0,0 +1,244 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// IRCv3 WebSocket subprotocols (https://ircv3.net/specs/extensions/websocket)
+	WSBinaryProtocol = "binary.ircv3.net"
+	WSTextProtocol   = "text.ircv3.net"
+
+	// WS_READ_LIMIT is the largest message a client may send: the tags
+	// with their leading '@' and trailing ' ', and a line
+	WS_READ_LIMIT = MAX_TAGS_LEN + 2 + 512
+
+	WS_HEADER_TIMEOUT = 10 * time.Second // how long a client may take to send its request headers
+)
+
+var (
+	ErrListenerClosed = errors.New("listener closed")
+)
+
+// WSConn adapts a WebSocket connection to a net.Conn carrying IRC
+// lines so it can be used by a Socket. Each message is one line without
+// the trailing CRLF.
+type WSConn struct {
+	ws     *websocket.Conn
+	binary bool
+	secure bool
+	certfp string
+
+	readBuf    []byte
+	writeBuf   []byte
+	writeMutex sync.Mutex
+}
+
+// NewWSConn returns a connection for ws upgraded from request r
+func NewWSConn(ws *websocket.Conn, r *http.Request) *WSConn {
+	conn := &WSConn{
+		ws:     ws,
+		binary: ws.Subprotocol() == WSBinaryProtocol,
+		secure: r.TLS != nil,
+	}
+	ws.SetReadLimit(WS_READ_LIMIT)
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		conn.certfp = hex.EncodeToString(sum[:])
+	}
+	return conn
+}
+
+func (conn *WSConn) Read(p []byte) (int, error) {
+	for len(conn.readBuf) == 0 {
+		_, message, err := conn.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		message = bytes.TrimRight(message, "\r\n")
+		if len(message) > 0 {
+			conn.readBuf = append(message, CRLF...)
+		}
+	}
+
+	n := copy(p, conn.readBuf)
+	conn.readBuf = conn.readBuf[n:]
+	return n, nil
+}
+
+func (conn *WSConn) Write(p []byte) (int, error) {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	conn.writeBuf = append(conn.writeBuf, p...)
+	for {
+		index := bytes.IndexByte(conn.writeBuf, '\n')
+		if index < 0 {
+			break
+		}
+		line := bytes.TrimRight(conn.writeBuf[:index], "\r")
+		conn.writeBuf = conn.writeBuf[index+1:]
+
+		var err error
+		if conn.binary {
+			err = conn.ws.WriteMessage(websocket.BinaryMessage, line)
+		} else {
+			// text messages must be valid UTF-8
+			if !utf8.Valid(line) {
+				line = bytes.ToValidUTF8(line, []byte("�"))
+			}
+			err = conn.ws.WriteMessage(websocket.TextMessage, line)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (conn *WSConn) Close() error {
+	return conn.ws.Close()
+}
+
+func (conn *WSConn) LocalAddr() net.Addr {
+	return conn.ws.LocalAddr()
+}
+
+func (conn *WSConn) RemoteAddr() net.Addr {
+	return conn.ws.RemoteAddr()
+}
+
+func (conn *WSConn) SetDeadline(t time.Time) error {
+	if err := conn.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return conn.ws.SetWriteDeadline(t)
+}
+
+func (conn *WSConn) SetReadDeadline(t time.Time) error {
+	return conn.ws.SetReadDeadline(t)
+}
+
+func (conn *WSConn) SetWriteDeadline(t time.Time) error {
+	return conn.ws.SetWriteDeadline(t)
+}
+
+// WSListener is a net.Listener accepting WebSocket connections made to
+// its HTTP handler.
+type WSListener struct {
+	addr     net.Addr
+	upgrader websocket.Upgrader
+	conns    chan net.Conn
+	done     chan bool
+	once     sync.Once
+}
+
+func NewWSListener(addr net.Addr, origins []string) *WSListener {
+	listener := &WSListener{
+		addr: addr,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{WSBinaryProtocol, WSTextProtocol},
+		},
+		conns: make(chan net.Conn),
+		done:  make(chan bool),
+	}
+	if len(origins) > 0 {
+		listener.upgrader.CheckOrigin = func(r *http.Request) bool {
+			return CheckOrigin(origins, r.Header.Get("Origin"))
+		}
+	}
+	return listener
+}
+
+// CheckOrigin returns true if origin matches one of the allowed origin
+// patterns. Requests without an origin are not made by browsers and are
+// always allowed.
+func CheckOrigin(origins []string, origin string) bool {
+	if origin == "" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range origins {
+		if ok, _ := path.Match(strings.ToLower(pattern), origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (listener *WSListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := listener.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debugf("websocket upgrade error from %s: %s", r.RemoteAddr, err)
+		return
+	}
+
+	select {
+	case listener.conns <- NewWSConn(ws, r):
+	case <-listener.done:
+		ws.Close()
+	}
+}
+
+func (listener *WSListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (listener *WSListener) Close() error {
+	listener.once.Do(func() {
+		close(listener.done)
+	})
+	return nil
+}
+
+func (listener *WSListener) Addr() net.Addr {
+	return listener.addr
+}
+
+//
+// listen websocket goroutine
+//
+
+func (s *Server) listenws(addr string, wsconfig *WebSocketConfig) {
+	listener := s.newListener(addr)
+
+	secure := ""
+	if wsconfig.Cert != "" {
+		listener = tls.NewListener(listener, s.tlsConfig(&wsconfig.TLSConfig))
+		secure = " (TLS)"
+	}
+
+	wsListener := NewWSListener(listener.Addr(), wsconfig.Origins)
+	server := &http.Server{
+		Handler:           wsListener,
+		ReadHeaderTimeout: WS_HEADER_TIMEOUT,
+	}
+	go func() {
+		err := server.Serve(listener)
+		log.Errorf("%s websocket listener on %s stopped: %s", s, addr, err)
+		wsListener.Close()
+	}()
+
+	log.Infof("%s listening on %s (WebSocket%s)", s, addr, secure)
+
+	go s.acceptor(wsListener)
+}
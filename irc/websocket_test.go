@@ -0,0 +1,90 @@
+package irc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialWSListener(t *testing.T, listener *WSListener, protocol string,
+	origin string) (*websocket.Conn, *Socket, error) {
+	server := httptest.NewServer(listener)
+	t.Cleanup(server.Close)
+
+	dialer := websocket.Dialer{Subprotocols: []string{protocol}}
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws, NewSocket(conn), nil
+}
+
+func TestWSConn(t *testing.T) {
+	for _, protocol := range []string{WSTextProtocol, WSBinaryProtocol} {
+		ws, socket, err := dialWSListener(t, NewWSListener(nil, nil), protocol, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws.Subprotocol() != protocol {
+			t.Errorf("Expected subprotocol %s, got %q", protocol, ws.Subprotocol())
+		}
+
+		ws.WriteMessage(websocket.TextMessage, []byte("NICK foo"))
+		ws.WriteMessage(websocket.TextMessage, []byte("USER foo 0 * :Foo\r\n"))
+		for _, expected := range []string{"NICK foo", "USER foo 0 * :Foo"} {
+			if line, err := socket.Read(); err != nil || line != expected {
+				t.Errorf("Expected %q, got %q (%v)", expected, line, err)
+			}
+		}
+
+		socket.Write(":test.server PING :test.server")
+		messageType, message, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(message) != ":test.server PING :test.server" {
+			t.Errorf("Unexpected message %q", message)
+		}
+		if (messageType == websocket.BinaryMessage) != (protocol == WSBinaryProtocol) {
+			t.Errorf("Unexpected message type %d for %s", messageType, protocol)
+		}
+	}
+}
+
+func TestWSListenerOrigins(t *testing.T) {
+	origins := []string{"https://*.example.com"}
+
+	if _, _, err := dialWSListener(t, NewWSListener(nil, origins), WSTextProtocol,
+		"https://chat.example.com"); err != nil {
+		t.Errorf("Expected allowed origin to connect, got %s", err)
+	}
+	if _, _, err := dialWSListener(t, NewWSListener(nil, origins), WSTextProtocol,
+		"https://evil.example.org"); err != websocket.ErrBadHandshake {
+		t.Errorf("Expected ErrBadHandshake, got %v", err)
+	}
+}
+
+func TestWSConnReadLimit(t *testing.T) {
+	ws, socket, err := dialWSListener(t, NewWSListener(nil, nil), WSTextProtocol, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", WS_READ_LIMIT+1)))
+	if line, err := socket.Read(); err == nil {
+		t.Errorf("Expected a message over the read limit to close the connection, got %q", line)
+	}
+}
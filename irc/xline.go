@@ -0,0 +1,486 @@
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// XLineType is the kind of a server ban
+type XLineType string
+
+const (
+	KLine XLineType = "K" // bans user@host masks
+	DLine XLineType = "D" // bans IP addresses and networks
+)
+
+var (
+	ErrXLineExists   = errors.New("ban already exists")
+	ErrXLineNotFound = errors.New("no such ban")
+	ErrXLineMask     = errors.New("invalid ban mask")
+	ErrDuration      = errors.New("invalid duration")
+
+	// audit events of adding and removing each kind of ban
+	xlineAuditEvents   = map[XLineType]AuditEvent{KLine: AuditKLine, DLine: AuditDLine}
+	unxlineAuditEvents = map[XLineType]AuditEvent{KLine: AuditUnKLine, DLine: AuditUnDLine}
+)
+
+// XLine is a server ban with the reason shown to banned clients
+type XLine struct {
+	Type    XLineType `json:"type"`
+	Mask    string    `json:"mask"`
+	Reason  string    `json:"reason"`
+	Oper    string    `json:"oper"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"` // zero if permanent
+
+	masks   *UserMaskSet
+	network *net.IPNet
+}
+
+// NewXLine returns a ban lasting duration, or forever if it is zero
+func NewXLine(kind XLineType, mask, reason, oper string, duration time.Duration) (*XLine, error) {
+	xline := &XLine{
+		Type:    kind,
+		Mask:    mask,
+		Reason:  reason,
+		Oper:    oper,
+		Created: time.Now(),
+	}
+	if duration > 0 {
+		xline.Expires = xline.Created.Add(duration)
+	}
+	if err := xline.compile(); err != nil {
+		return nil, err
+	}
+	return xline, nil
+}
+
+func (xline *XLine) compile() error {
+	switch xline.Type {
+	case KLine:
+		if strings.Count(xline.Mask, "@") != 1 || strings.Contains(xline.Mask, "!") {
+			return ErrXLineMask
+		}
+		xline.masks = NewUserMaskSet()
+		xline.masks.Add(NewName(strings.ToLower(xline.Mask)))
+	case DLine:
+		networks, err := ParseNetworks([]string{xline.Mask})
+		if err != nil {
+			return ErrXLineMask
+		}
+		xline.network = networks[0]
+		xline.Mask = xline.network.String()
+		if ones, bits := xline.network.Mask.Size(); ones == bits {
+			xline.Mask = xline.network.IP.String()
+		}
+	default:
+		return ErrXLineMask
+	}
+	return nil
+}
+
+func (xline *XLine) key() string {
+	return fmt.Sprintf("%s %s", xline.Type, strings.ToLower(xline.Mask))
+}
+
+// Expired returns true if the ban has expired
+func (xline *XLine) Expired() bool {
+	return !xline.Expires.IsZero() && time.Now().After(xline.Expires)
+}
+
+// Description returns the reason of the ban and when it expires
+func (xline *XLine) Description() string {
+	if xline.Expires.IsZero() {
+		return xline.Reason
+	}
+	return fmt.Sprintf("%s (expires %s)", xline.Reason, xline.Expires.Format(time.RFC1123))
+}
+
+// MatchIP returns true if a D-line matches ip
+func (xline *XLine) MatchIP(ip net.IP) bool {
+	return xline.network != nil && ip != nil && xline.network.Contains(ip)
+}
+
+// MatchUserHost returns true if a K-line matches user@host
+func (xline *XLine) MatchUserHost(userhost string) bool {
+	return xline.masks != nil && xline.masks.Match(NewName(strings.ToLower(userhost)))
+}
+
+// ParseBanDuration parses the duration of a ban: a number of minutes or
+// a duration such as 90m, 12h, 7d or 2w.
+func ParseBanDuration(s string) (time.Duration, error) {
+	if minutes, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return time.Duration(minutes) * time.Minute, nil
+	}
+
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			n, err := strconv.ParseUint(s[:len(s)-1], 10, 32)
+			if err != nil {
+				return 0, ErrDuration
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil || duration < 0 {
+		return 0, ErrDuration
+	}
+	return duration, nil
+}
+
+type XLineStore interface {
+	All() ([]*XLine, error)
+	Set(xline *XLine) error
+	Delete(xline *XLine) error
+}
+
+// MemoryXLineStore keeps server bans for the lifetime of the server only
+type MemoryXLineStore struct {
+	sync.RWMutex
+	xlines map[string]*XLine
+}
+
+func NewMemoryXLineStore() *MemoryXLineStore {
+	return &MemoryXLineStore{xlines: make(map[string]*XLine)}
+}
+
+func (store *MemoryXLineStore) All() ([]*XLine, error) {
+	store.RLock()
+	defer store.RUnlock()
+
+	xlines := make([]*XLine, 0, len(store.xlines))
+	for _, xline := range store.xlines {
+		xlines = append(xlines, xline)
+	}
+	return xlines, nil
+}
+
+func (store *MemoryXLineStore) Set(xline *XLine) error {
+	store.Lock()
+	defer store.Unlock()
+
+	store.xlines[xline.key()] = xline
+	return nil
+}
+
+func (store *MemoryXLineStore) Delete(xline *XLine) error {
+	store.Lock()
+	defer store.Unlock()
+
+	delete(store.xlines, xline.key())
+	return nil
+}
+
+// BoltXLineStore keeps server bans in the "xlines" bucket of a BoltDB
+// database.
+type BoltXLineStore struct {
+	db *bolt.DB
+}
+
+func NewBoltXLineStore(db *bolt.DB) *BoltXLineStore {
+	return &BoltXLineStore{db: db}
+}
+
+func (store *BoltXLineStore) All() ([]*XLine, error) {
+	xlines := make([]*XLine, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("xlines")).ForEach(func(key, value []byte) error {
+			xline := &XLine{}
+			if err := json.Unmarshal(value, xline); err != nil {
+				log.Errorf("error loading ban %s: %s", key, err)
+				return nil
+			}
+			xlines = append(xlines, xline)
+			return nil
+		})
+	})
+	return xlines, err
+}
+
+func (store *BoltXLineStore) Set(xline *XLine) error {
+	data, err := json.Marshal(xline)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("xlines")).Put([]byte(xline.key()), data)
+	})
+}
+
+func (store *BoltXLineStore) Delete(xline *XLine) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("xlines")).Delete([]byte(xline.key()))
+	})
+}
+
+// XLines are the server bans in effect
+type XLines struct {
+	sync.RWMutex
+	store  XLineStore
+	xlines map[string]*XLine
+}
+
+// NewXLines loads the bans kept in store
+func NewXLines(store XLineStore) (*XLines, error) {
+	all, err := store.All()
+	if err != nil {
+		return nil, err
+	}
+
+	xlines := &XLines{
+		store:  store,
+		xlines: make(map[string]*XLine),
+	}
+	for _, xline := range all {
+		if err := xline.compile(); err != nil {
+			log.Errorf("invalid ban %s %s: %s", xline.Type, xline.Mask, err)
+			continue
+		}
+		xlines.xlines[xline.key()] = xline
+	}
+	xlines.expire()
+	return xlines, nil
+}
+
+// expire removes expired bans
+func (xlines *XLines) expire() {
+	xlines.Lock()
+	defer xlines.Unlock()
+
+	for key, xline := range xlines.xlines {
+		if !xline.Expired() {
+			continue
+		}
+		delete(xlines.xlines, key)
+		if err := xlines.store.Delete(xline); err != nil {
+			log.Errorf("error removing expired ban %s: %s", key, err)
+		}
+	}
+}
+
+// Add adds a ban replacing any ban of the same mask
+func (xlines *XLines) Add(xline *XLine) error {
+	xlines.Lock()
+	defer xlines.Unlock()
+
+	if err := xlines.store.Set(xline); err != nil {
+		return err
+	}
+	xlines.xlines[xline.key()] = xline
+	return nil
+}
+
+// Remove removes the ban of mask
+func (xlines *XLines) Remove(kind XLineType, mask string) (*XLine, error) {
+	xlines.Lock()
+	defer xlines.Unlock()
+
+	lookup := &XLine{Type: kind, Mask: mask}
+	if kind == DLine && lookup.compile() != nil {
+		return nil, ErrXLineNotFound
+	}
+	xline, ok := xlines.xlines[lookup.key()]
+	if !ok {
+		return nil, ErrXLineNotFound
+	}
+	if err := xlines.store.Delete(xline); err != nil {
+		return nil, err
+	}
+	delete(xlines.xlines, xline.key())
+	return xline, nil
+}
+
+// List returns the bans of a type in effect sorted by mask
+func (xlines *XLines) List(kind XLineType) []*XLine {
+	xlines.expire()
+
+	xlines.RLock()
+	defer xlines.RUnlock()
+
+	list := make([]*XLine, 0)
+	for _, xline := range xlines.xlines {
+		if xline.Type == kind {
+			list = append(list, xline)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Mask < list[j].Mask
+	})
+	return list
+}
+
+// MatchIP returns the D-line banning ip if any
+func (xlines *XLines) MatchIP(ip net.IP) *XLine {
+	xlines.RLock()
+	defer xlines.RUnlock()
+
+	for _, xline := range xlines.xlines {
+		if xline.MatchIP(ip) && !xline.Expired() {
+			return xline
+		}
+	}
+	return nil
+}
+
+// MatchClient returns the ban matching the client if any
+func (xlines *XLines) MatchClient(client *Client) *XLine {
+	if xline := xlines.MatchIP(client.ip); xline != nil {
+		return xline
+	}
+
+	xlines.RLock()
+	defer xlines.RUnlock()
+
+	username := client.username.String()
+	userhosts := []string{
+		username + "@" + client.hostname.String(),
+		username + "@" + client.hostmask.String(),
+	}
+	if client.cloak != "" && client.cloak != client.hostmask {
+		userhosts = append(userhosts, username+"@"+client.cloak.String())
+	}
+	if client.ip != nil {
+		userhosts = append(userhosts, username+"@"+client.ip.String())
+	}
+	for _, xline := range xlines.xlines {
+		if xline.Expired() {
+			continue
+		}
+		for _, userhost := range userhosts {
+			if xline.MatchUserHost(userhost) {
+				return xline
+			}
+		}
+	}
+	return nil
+}
+
+// Ban adds a ban and disconnects the local clients it matches
+func (server *Server) Ban(xline *XLine) error {
+	if err := server.xlines.Add(xline); err != nil {
+		return err
+	}
+
+	banned := make([]*Client, 0)
+	server.clients.Range(func(_ Name, client *Client) bool {
+		if client.link == nil && server.xlines.MatchClient(client) != nil {
+			banned = append(banned, client)
+		}
+		return true
+	})
+	for _, client := range banned {
+		client.ErrYoureBannedCreep(xline.Description())
+		client.Quit(NewText(fmt.Sprintf("%s-lined", xline.Type)))
+	}
+	return nil
+}
+
+//
+// commands
+//
+
+// banMask returns the mask of a K-line or D-line for mask, which may be
+// the nickname of a connected client.
+func (server *Server) banMask(kind XLineType, mask string) string {
+	target := server.clients.Get(NewName(mask))
+	switch kind {
+	case KLine:
+		if strings.Contains(mask, "@") {
+			return mask
+		}
+		if target != nil {
+			return "*@" + target.hostname.String()
+		}
+		return "*@" + mask
+	case DLine:
+		if target != nil && target.ip != nil {
+			return target.ip.String()
+		}
+	}
+	return mask
+}
+
+func (msg *XLineCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.HasPrivilege(PrivKline) {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	mask := server.banMask(msg.kind, msg.mask)
+	reason := msg.reason
+	if reason == "" {
+		reason = "No reason given"
+	}
+
+	xline, err := NewXLine(msg.kind, mask, reason, client.Nick().String(), msg.duration)
+	if err != nil {
+		client.Reply(RplNotice(server, client, NewText(
+			fmt.Sprintf("Invalid %s-line mask %s", msg.kind, mask))))
+		return
+	}
+
+	if err := server.Ban(xline); err != nil {
+		log.Errorf("error adding %s-line for %s: %s", xline.Type, xline.Mask, err)
+		client.Reply(RplNotice(server, client, NewText(
+			fmt.Sprintf("Error adding %s-line for %s: %s", xline.Type, xline.Mask, err))))
+		return
+	}
+
+	server.Wallopsf("%s added %s-line for %s: %s",
+		client.Nick(), xline.Type, xline.Mask, xline.Description())
+	server.Audit(NewAuditEntry(xlineAuditEvents[xline.Type], client, xline.Mask, xline.Description()))
+}
+
+func (msg *UnXLineCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.HasPrivilege(PrivKline) {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	xline, err := server.xlines.Remove(msg.kind, msg.mask)
+	if err != nil {
+		client.Reply(RplNotice(server, client, NewText(
+			fmt.Sprintf("Error removing %s-line for %s: %s", msg.kind, msg.mask, err))))
+		return
+	}
+
+	server.Wallopsf("%s removed %s-line for %s", client.Nick(), xline.Type, xline.Mask)
+	server.Audit(NewAuditEntry(unxlineAuditEvents[xline.Type], client, xline.Mask, ""))
+}
+
+func (msg *StatsCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	switch msg.query {
+	case "k", "K", "d", "D":
+		if !client.HasPrivilege(PrivKline) {
+			client.ErrNoPrivileges()
+			return
+		}
+		kind := XLineType(strings.ToUpper(msg.query))
+		for _, xline := range server.xlines.List(kind) {
+			client.RplStatsXLine(xline)
+		}
+
+	case "u":
+		client.RplStatsUptime()
+	}
+
+	client.RplEndOfStats(msg.query)
+}
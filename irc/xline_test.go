@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseBanDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30":   30 * time.Minute,
+		"90m":  90 * time.Minute,
+		"12h":  12 * time.Hour,
+		"7d":   7 * 24 * time.Hour,
+		"2w":   14 * 24 * time.Hour,
+		"1h5m": 65 * time.Minute,
+	}
+	for s, expected := range tests {
+		if duration, err := ParseBanDuration(s); err != nil || duration != expected {
+			t.Errorf("Expected %s for %q, got %s (%v)", expected, s, duration, err)
+		}
+	}
+
+	for _, s := range []string{"", "*@host", "d", "-1h"} {
+		if _, err := ParseBanDuration(s); err == nil {
+			t.Errorf("Expected %q to be invalid", s)
+		}
+	}
+}
+
+func TestXLines(t *testing.T) {
+	store := NewMemoryXLineStore()
+	xlines, err := NewXLines(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kline, err := NewXLine(KLine, "*@*.Example.com", "spam", "oper", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dline, err := NewXLine(DLine, "192.0.2.0/24", "abuse", "oper", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := NewXLine(DLine, "198.51.100.1", "old", "oper", time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, xline := range []*XLine{kline, dline, expired} {
+		if err := xlines.Add(xline); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+
+	if _, err := NewXLine(KLine, "nick!user@host", "", "oper", 0); err != ErrXLineMask {
+		t.Errorf("Expected ErrXLineMask for a nick mask, got %v", err)
+	}
+	if _, err := NewXLine(DLine, "not-an-ip", "", "oper", 0); err != ErrXLineMask {
+		t.Errorf("Expected ErrXLineMask for an invalid network, got %v", err)
+	}
+
+	client := &Client{
+		username: "foo",
+		hostname: "host.example.com",
+		ip:       net.ParseIP("203.0.113.1"),
+	}
+	if xline := xlines.MatchClient(client); xline != kline {
+		t.Errorf("Expected the K-line to match %s, got %v", client.hostname, xline)
+	}
+	client.hostname = "host.example.org"
+	if xline := xlines.MatchClient(client); xline != nil {
+		t.Errorf("Expected no match for %s, got %v", client.hostname, xline)
+	}
+	client.ip = net.ParseIP("192.0.2.7")
+	if xline := xlines.MatchClient(client); xline != dline {
+		t.Errorf("Expected the D-line to match %s, got %v", client.ip, xline)
+	}
+	if xline := xlines.MatchIP(net.ParseIP("198.51.100.1")); xline != nil {
+		t.Errorf("Expected the expired D-line not to match, got %v", xline)
+	}
+	if list := xlines.List(DLine); len(list) != 1 || list[0] != dline {
+		t.Errorf("Expected only the unexpired D-line to be listed, got %v", list)
+	}
+
+	// bans are loaded from the store
+	xlines, err = NewXLines(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := xlines.List(KLine); len(list) != 1 || list[0].Mask != kline.Mask {
+		t.Errorf("Expected the K-line to be loaded, got %v", list)
+	}
+
+	if _, err := xlines.Remove(KLine, "*@*.EXAMPLE.COM"); err != nil {
+		t.Errorf("Expected the K-line to be removed, got %s", err)
+	}
+	if _, err := xlines.Remove(KLine, "*@*.example.com"); err != ErrXLineNotFound {
+		t.Errorf("Expected ErrXLineNotFound, got %v", err)
+	}
+	if all, _ := store.All(); len(all) != 1 {
+		t.Errorf("Expected one ban left in the store, got %d", len(all))
+	}
+}